@@ -4,27 +4,37 @@ package core
 // Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
 
 import (
+	"errors"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/vkuznet/WorkQueue/services"
 	"github.com/vkuznet/WorkQueue/utils"
-	"github.com/zemirco/couchdb"
 )
 
+func init() {
+	RegisterPolicy("Block", func(record, config utils.Record) Policy {
+		return &BlockPolicy{Name: "Block", Record: record, Config: config}
+	})
+}
+
 // BlockPolicy defines block policy
 type BlockPolicy struct {
 	Name   string
 	Record utils.Record
 	Config utils.Record
+	Queued []string // blocks already in WorkQueue, used to split new data of open requests
 }
 
 // Split method satisfy Policy interface
-func (b *BlockPolicy) Split() []couchdb.CouchDoc {
-	var out []couchdb.CouchDoc
-	var inputBlocks, parentData, pileupData map[string][]string
-	var numberOfLumis, numberOfFiles, numberOfEvents, jobs, blowupFactor, priority, filesProcessed int
-	var parentFlag, openForNewData, noInputUpdate, noPileupUpdate bool
-	var mask map[string]int
-	var acdc, requestName, taskName, dbs, wmSpec, parentQueueUrl, childQueueUrl, wmbsUrl, sPolicy, ePolicy string
+// Block request produces one element per (masked) block of input dataset,
+// blocks listed in Queued are skipped
+func (b *BlockPolicy) Split() ([]WorkQueueElement, error) {
+	var out []WorkQueueElement
+	var priority, filesProcessed int
+	var openForNewData bool
+	var acdc ACDCInfo
+	var requestName, taskName, dbs, wmSpec, parentQueueUrl, childQueueUrl, wmbsUrl, sPolicy, ePolicy string
 	var siteWhiteList, siteBlackList []string
 	var percentSuccess, percentComplete float32
 	v1 := configValue(b.Config, "policies.start.policyName")
@@ -36,72 +46,126 @@ func (b *BlockPolicy) Split() []couchdb.CouchDoc {
 		ePolicy = v2.(string)
 	}
 	for rname, spec := range b.Record { // reqMgr2 record is {request_name: request_spec}
-		if InWorkQueue(rname) {
+		if len(b.Queued) == 0 && InWorkQueue(rname) {
 			break
 		}
 		switch rec := spec.(type) {
 		case map[string]interface{}:
 			requestName, _ = rec["RequestName"].(string)
 			if rname != requestName {
-				log.Warn("ReqMgr2 rname=%s != RequestName=%s", rname, requestName)
+				log.Warnf("ReqMgr2 rname=%s != RequestName=%s", rname, requestName)
 			}
 			taskName = requestName
 			dbs, _ = rec["DbsUrl"].(string)
-			siteWhiteList, _ = rec["siteWhitelist"].([]string)
-			siteBlackList, _ = rec["whiteBlacklist"].([]string)
-			priority, _ = rec["RequestPriority"].(int)
+			siteWhiteList = stringList(rec["SiteWhitelist"])
+			siteBlackList = stringList(rec["SiteBlacklist"])
+			priority = intValue(rec["RequestPriority"])
 			wmSpec, _ = rec["RequestWorkflow"].(string)
-			inputDataset, _ := rec["InputDataset"].(string)
-			blocks := services.Blocks(inputDataset)
-			maskedBlocks := services.MaskedBlocks(blocks)
+			inputDataset, _ := specValue(rec, "InputDataset").(string)
+			filter := NewRunLumiFilter(rec)
+			openForNewData = intValue(specValue(rec, "OpenRunningTimeout")) > 0
+			foundNewData := time.Now().Unix()
+			var blocks []string
+			for _, block := range filter.Blocks(services.Blocks(inputDataset)) {
+				if !utils.InList(block, b.Queued) {
+					blocks = append(blocks, block)
+				}
+			}
+			if len(b.Queued) > 0 && len(blocks) == 0 {
+				continue // no new data
+			}
+			splitting := NewSplitting(rec, b.Config)
+			blowupFactor := BlowupFactor(rec)
+			parentFlag := boolValue(rec, "IncludeParents")
+			noInputUpdate := boolValue(rec, "TrustSitelists")
+			noPileupUpdate := boolValue(rec, "TrustPUSitelists")
+			pileupData := pileupLocations(rec)
+
+			// apply run/lumi selection and drop blocks without selected data
+			var maskedBlocks []services.MaskedBlock
 			var mblocks []string
-			for _, mb := range maskedBlocks {
-				numberOfLumis += mb.NumberOfLumis()
-				numberOfFiles += mb.NumberOfFiles()
-				numberOfEvents += mb.NumberOfEvents()
+			for _, block := range services.MaskedBlocks(blocks) {
+				mb := filter.Apply(block)
+				if mb.NumberOfLumis() == 0 {
+					continue
+				}
+				maskedBlocks = append(maskedBlocks, mb)
 				mblocks = append(mblocks, mb.Block)
 			}
-			inputBlocks = services.Blocks2Sites(mblocks)
-			parentData = services.Blocks2Sites(services.ParentBlocks(mblocks))
+			if len(mblocks) == 0 {
+				log.WithFields(log.Fields{"request": requestName, "dataset": inputDataset}).Warn("No input data selected by request run/lumi/block lists")
+				continue
+			}
+			blockSites := services.Blocks2Sites(mblocks)
+			blockParents := make(map[string][]string)
+			parentSites := make(map[string][]string)
+			if parentFlag {
+				blockParents = services.BlockParents(mblocks)
+				var parents []string
+				for _, pblocks := range blockParents {
+					parents = append(parents, pblocks...)
+				}
+				parentSites = services.Blocks2Sites(utils.List2Set(parents))
+			}
 
-			wqe := &WorkQueueElement{
-				Inputs:          inputBlocks,
-				ParentFlag:      parentFlag,
-				ParentData:      parentData,
-				PileupData:      pileupData,
-				NumberOfLumis:   numberOfLumis,
-				NumberOfFiles:   numberOfFiles,
-				NumberOfEvents:  numberOfEvents,
-				Jobs:            jobs,
-				OpenForNewData:  openForNewData,
-				NoInputUpdate:   noInputUpdate,
-				NoPileupUpdate:  noPileupUpdate,
-				WMSpec:          wmSpec,
-				Mask:            mask,
-				BlowupFactor:    blowupFactor,
-				ACDC:            acdc,
-				Dbs:             dbs,
-				TaskName:        taskName,
-				RequestName:     requestName,
-				SiteWhiteList:   siteWhiteList,
-				SiteBlackList:   siteBlackList,
-				Priority:        priority,
-				ParentQueueUrl:  parentQueueUrl,
-				ChildQueueUrl:   childQueueUrl,
-				PercentSuccess:  percentSuccess,
-				PercentComplete: percentComplete,
-				WMBSUrl:         wmbsUrl,
-				FilesProcessed:  filesProcessed,
-				StartPolicy:     sPolicy,
-				EndPolicy:       ePolicy,
+			// create one element per block
+			for _, mb := range maskedBlocks {
+				var mask Mask
+				if filter.Active() {
+					addRunLumis(&mask, mb)
+				}
+				parentData := make(map[string][]string)
+				for _, pblk := range blockParents[mb.Block] {
+					parentData[pblk] = parentSites[pblk]
+				}
+				wqe := WorkQueueElement{
+					Inputs:          map[string][]string{mb.Block: blockSites[mb.Block]},
+					ParentFlag:      parentFlag,
+					ParentData:      parentData,
+					PileupData:      pileupData,
+					NumberOfLumis:   mb.NumberOfLumis(),
+					NumberOfFiles:   mb.NumberOfFiles(),
+					NumberOfEvents:  mb.NumberOfEvents(),
+					Jobs:            splitting.Jobs(mb.NumberOfFiles(), mb.NumberOfLumis(), mb.NumberOfEvents()),
+					OpenForNewData:  openForNewData,
+					FoundNewData:    foundNewData,
+					NoInputUpdate:   noInputUpdate,
+					NoPileupUpdate:  noPileupUpdate,
+					WMSpec:          wmSpec,
+					Mask:            mask,
+					BlowupFactor:    blowupFactor,
+					ACDC:            acdc,
+					Dbs:             dbs,
+					TaskName:        taskName,
+					RequestName:     requestName,
+					SiteWhiteList:   siteWhiteList,
+					SiteBlackList:   siteBlackList,
+					Priority:        priority,
+					ParentQueueUrl:  parentQueueUrl,
+					ChildQueueUrl:   childQueueUrl,
+					PercentSuccess:  percentSuccess,
+					PercentComplete: percentComplete,
+					WMBSUrl:         wmbsUrl,
+					FilesProcessed:  filesProcessed,
+					StartPolicy:     sPolicy,
+					EndPolicy:       ePolicy,
+				}
+				wqe.checkLocation()
+				out = append(out, wqe)
 			}
-			out = append(out, wqe)
 		}
 	}
-	return out
+	return out, nil
 }
 
-// Split method satisfy Policy interface
-func (b *BlockPolicy) Validate() bool {
-	return true
+// Validate method satisfy Policy interface
+func (b *BlockPolicy) Validate() error {
+	_, rec := requestSpec(b.Record)
+	if rec == nil {
+		return errors.New("Block policy: no request spec in ReqMgr2 record")
+	}
+	if v, _ := specValue(rec, "InputDataset").(string); v == "" {
+		return errors.New("Block policy: request does not specify InputDataset")
+	}
+	return nil
 }
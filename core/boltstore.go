@@ -0,0 +1,293 @@
+package core
+
+// WorkQueue embedded storage implementation based on bbolt key-value store
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket of WorkQueue elements, {id: JSON representation of element}
+var elementsBucket = []byte("elements")
+
+// buckets of secondary indexes, {value + separator + id: nil}
+var indexBuckets = []string{"request", "status", "team", "site"}
+
+// separator of index value and element id in index keys
+const indexSeparator = "\x00"
+
+// BoltStore implements Store interface on top of embedded bbolt database,
+// every write is done in a transaction which is synced to disk on commit
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) bbolt database in given file and returns
+// new instance of BoltStore
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(elementsBucket); err != nil {
+			return err
+		}
+		for _, name := range indexBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes underlying bbolt database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// helper function to generate random id
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// helper function to return index values of the element
+func indexValues(wqe WorkQueueElement) map[string][]string {
+	return map[string][]string{
+		"request": {wqe.RequestName},
+		"status":  {wqe.Status},
+		"team":    wqe.Teams,
+		"site":    wqe.PossibleSites(),
+	}
+}
+
+// helper function to build index key from index value and element id
+func indexKey(value, id string) []byte {
+	return []byte(value + indexSeparator + id)
+}
+
+// helper function to add (or remove) index entries of the element
+func updateIndexes(tx *bolt.Tx, wqe WorkQueueElement, remove bool) error {
+	for name, values := range indexValues(wqe) {
+		bucket := tx.Bucket([]byte(name))
+		for _, value := range values {
+			var err error
+			if remove {
+				err = bucket.Delete(indexKey(value, wqe.ID))
+			} else {
+				err = bucket.Put(indexKey(value, wqe.ID), nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to load element within transaction
+func loadElement(tx *bolt.Tx, id string) (WorkQueueElement, error) {
+	var wqe WorkQueueElement
+	data := tx.Bucket(elementsBucket).Get([]byte(id))
+	if data == nil {
+		return wqe, ErrNotFound
+	}
+	err := json.Unmarshal(data, &wqe)
+	return wqe, err
+}
+
+// helper function to store element with next revision within transaction
+func saveElement(tx *bolt.Tx, wqe *WorkQueueElement) error {
+	n := 0
+	if wqe.Rev != "" {
+		n, _ = strconv.Atoi(strings.SplitN(wqe.Rev, "-", 2)[0])
+	}
+	wqe.Rev = fmt.Sprintf("%d-%s", n+1, newID())
+	data, err := json.Marshal(wqe)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(elementsBucket).Put([]byte(wqe.ID), data); err != nil {
+		return err
+	}
+	return updateIndexes(tx, *wqe, false)
+}
+
+// helper function to return elements with given index value
+func (s *BoltStore) index(name, value string) []WorkQueueElement {
+	var out []WorkQueueElement
+	s.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(value + indexSeparator)
+		c := tx.Bucket([]byte(name)).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if wqe, err := loadElement(tx, string(k[len(prefix):])); err == nil {
+				out = append(out, wqe)
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// helper function to return all elements
+func (s *BoltStore) all() []WorkQueueElement {
+	var out []WorkQueueElement
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(elementsBucket).ForEach(func(k, v []byte) error {
+			var wqe WorkQueueElement
+			if err := json.Unmarshal(v, &wqe); err == nil {
+				out = append(out, wqe)
+			}
+			return nil
+		})
+	})
+	return out
+}
+
+// Insert method satisfy Store interface, elements are inserted in a single
+// transaction, i.e. either all of them are stored or none
+func (s *BoltStore) Insert(elements []WorkQueueElement) (InsertSummary, error) {
+	var summary InsertSummary
+	// elements get their id and revision only if transaction is committed
+	inserted := make([]WorkQueueElement, len(elements))
+	copy(inserted, elements)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		summary = InsertSummary{}
+		for i := range inserted {
+			wqe := &inserted[i]
+			if wqe.ID == "" {
+				wqe.ID = ElementID(*wqe)
+			}
+			if stored, err := loadElement(tx, wqe.ID); err == nil {
+				merged, changed := mergeElement(stored, *wqe)
+				if changed {
+					if err := updateIndexes(tx, stored, true); err != nil {
+						return err
+					}
+					if err := saveElement(tx, &merged); err != nil {
+						return err
+					}
+				}
+				*wqe = merged
+				summary.Merged = append(summary.Merged, wqe.ID)
+				continue
+			}
+			wqe.Rev = ""
+			if err := saveElement(tx, wqe); err != nil {
+				return err
+			}
+			summary.Inserted = append(summary.Inserted, wqe.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		summary = InsertSummary{}
+		for _, wqe := range inserted {
+			summary.fail(wqe.ID, err)
+		}
+		return summary, err
+	}
+	copy(elements, inserted)
+	return summary, nil
+}
+
+// Get method satisfy Store interface
+func (s *BoltStore) Get(id string) (WorkQueueElement, error) {
+	var wqe WorkQueueElement
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		wqe, err = loadElement(tx, id)
+		return err
+	})
+	return wqe, err
+}
+
+// Request method satisfy Store interface
+func (s *BoltStore) Request(rname string) []WorkQueueElement {
+	if rname == "" {
+		return s.all()
+	}
+	return s.index("request", rname)
+}
+
+// Team returns elements assigned to given team
+func (s *BoltStore) Team(team string) []WorkQueueElement {
+	return s.index("team", team)
+}
+
+// Query method satisfy Store interface
+func (s *BoltStore) Query(status, site string) []WorkQueueElement {
+	var elements []WorkQueueElement
+	switch {
+	case site != "":
+		elements = s.index("site", site)
+	case status != "":
+		elements = s.index("status", status)
+	default:
+		elements = s.all()
+	}
+	var out []WorkQueueElement
+	for _, wqe := range elements {
+		if matchQuery(wqe, status, site) {
+			out = append(out, wqe)
+		}
+	}
+	sortByPriority(out)
+	return out
+}
+
+// Update method satisfy Store interface
+func (s *BoltStore) Update(wqe *WorkQueueElement) error {
+	updated := *wqe
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := loadElement(tx, updated.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Rev != updated.Rev {
+			return ErrConflict
+		}
+		if err := updateIndexes(tx, stored, true); err != nil {
+			return err
+		}
+		return saveElement(tx, &updated)
+	})
+	if err == nil {
+		wqe.Rev = updated.Rev
+	}
+	return err
+}
+
+// Delete method satisfy Store interface
+func (s *BoltStore) Delete(wqe WorkQueueElement) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := loadElement(tx, wqe.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Rev != wqe.Rev {
+			return ErrConflict
+		}
+		if err := updateIndexes(tx, stored, true); err != nil {
+			return err
+		}
+		return tx.Bucket(elementsBucket).Delete([]byte(wqe.ID))
+	})
+}
@@ -8,13 +8,13 @@ import (
 	"log" // keep standard log here since we used it in metrics, do not use logrus
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
 	"github.com/vkuznet/WorkQueue/services"
 	"github.com/vkuznet/WorkQueue/utils"
-	"github.com/zemirco/couchdb"
 )
 
 // Job represents the job to be run with given request
@@ -64,8 +64,14 @@ func (w Worker) Start() {
 					Process(job.Request)
 				} else if job.Type == "cleanup" {
 					Cleanup(job.Request)
+				} else if job.Type == "newdata" {
+					AddNewData(job.Request)
+				} else if job.Type == "lease" {
+					ExpireLeases(job.Request)
+				} else if job.Type == "location" {
+					UpdateLocations(job.Request)
 				} else {
-					logrus.Warn("Unsupported job type: %s", job.Type)
+					logrus.Warnf("Unsupported job type: %s", job.Type)
 				}
 			case <-w.quit:
 				// we have received a signal to stop
@@ -104,7 +110,7 @@ func NewDispatcher(maxWorkers, maxQueue int, mfile string, minterval int64) *Dis
 }
 
 // Run function starts the worker and dispatch it as go-routine
-func (d *Dispatcher) Run(rtype string, interval, cleanup int64) {
+func (d *Dispatcher) Run(rtype string, interval, cleanup, location int64) {
 	// starting n number of workers
 	for i := 0; i < d.MaxWorkers; i++ {
 		worker := NewWorker(i, d.JobPool)
@@ -114,6 +120,12 @@ func (d *Dispatcher) Run(rtype string, interval, cleanup int64) {
 	go d.dispatch(rtype, interval)
 	// spawn new go-routine to clean-up requests in WorkQueue
 	go d.cleanup(cleanup)
+	// spawn new go-routine to requeue elements with expired lease
+	go d.leases(cleanup)
+	// spawn new go-routine to refresh data location of WorkQueue elements
+	if location > 0 {
+		go d.location(location)
+	}
 }
 
 // helper function to dispatch jobs from ReqMgr2
@@ -136,21 +148,109 @@ func (d *Dispatcher) dispatch(rtype string, interval int64) {
 	}
 }
 
+// Watch function subscribes dispatcher to element events, the request is
+// submitted to clean-up as soon as its element reaches final state, i.e.
+// end policy evaluation and ReqMgr2 status propagation do not wait for
+// next clean-up cycle
+func (d *Dispatcher) Watch() {
+	events := Subscribe()
+	var mutex sync.Mutex
+	pending := make(map[string]bool) // requests waiting for a worker
+	go func() {
+		for event := range events {
+			if event.Type != ElementStatusChanged || !(WorkQueueElement{Status: event.Status}).InEndState() {
+				continue
+			}
+			mutex.Lock()
+			if pending[event.Request] {
+				mutex.Unlock()
+				continue
+			}
+			pending[event.Request] = true
+			mutex.Unlock()
+			go func(rname string) {
+				// try to obtain a worker job channel that is available.
+				// this will block until a worker is idle
+				jobChannel := <-d.JobPool
+				mutex.Lock()
+				delete(pending, rname)
+				mutex.Unlock()
+				elements := Storage.Request(rname)
+				if len(elements) == 0 {
+					d.JobPool <- jobChannel // return unused worker to the pool
+					return
+				}
+				job := Job{Request: utils.Record{rname: elements}, Type: "cleanup"}
+				jobChannel <- job
+			}(event.Request)
+		}
+	}()
+}
+
 // helper function to cleanup WorkQueue
 func (d *Dispatcher) cleanup(interval int64) {
 	for {
-		// fetch new set of requests from ReqMgr2
-		requests := GetWorkQueueElements("") // here we pass empty key
-		for _, req := range requests {
-			// submit request to processing chain
-			go func(req utils.Record) {
+		// fetch elements of all requests in WorkQueue
+		for rname, elements := range RequestElements("") {
+			// submit request elements to processing chain
+			go func(rname string, elements []WorkQueueElement) {
 				// try to obtain a worker job channel that is available.
 				// this will block until a worker is idle
 				jobChannel := <-d.JobPool
 				// dispatch the request to the worker job channel
-				job := Job{Request: req, Type: "cleanup"}
+				job := Job{Request: utils.Record{rname: elements}, Type: "cleanup"}
 				jobChannel <- job
-			}(req)
+			}(rname, elements)
+			if !openForNewData(elements) {
+				continue
+			}
+			// submit request open for new data to look-up its new blocks
+			go func(rname string, elements []WorkQueueElement) {
+				jobChannel := <-d.JobPool
+				job := Job{Request: utils.Record{rname: elements}, Type: "newdata"}
+				jobChannel <- job
+			}(rname, elements)
+		}
+		time.Sleep(time.Duration(interval) * time.Second) // wait for a job
+	}
+}
+
+// helper function to requeue WorkQueue elements with expired lease
+func (d *Dispatcher) leases(interval int64) {
+	for {
+		if elements := expiredElements(); len(elements) > 0 {
+			// submit expired elements to processing chain
+			go func(elements []WorkQueueElement) {
+				// try to obtain a worker job channel that is available.
+				// this will block until a worker is idle
+				jobChannel := <-d.JobPool
+				// dispatch the request to the worker job channel
+				job := Job{Request: utils.Record{"elements": elements}, Type: "lease"}
+				jobChannel <- job
+			}(elements)
+		}
+		time.Sleep(time.Duration(interval) * time.Second) // wait for a job
+	}
+}
+
+// helper function to refresh data location of Available WorkQueue elements
+func (d *Dispatcher) location(interval int64) {
+	for {
+		elements := Storage.Query(Available, "")
+		for i := 0; i < len(elements); i += locationBatchSize {
+			end := i + locationBatchSize
+			if end > len(elements) {
+				end = len(elements)
+			}
+			// submit batch of elements to processing chain
+			go func(batch []WorkQueueElement) {
+				// try to obtain a worker job channel that is available.
+				// this will block until a worker is idle
+				jobChannel := <-d.JobPool
+				// dispatch the request to the worker job channel
+				job := Job{Request: utils.Record{"elements": batch}, Type: "location"}
+				jobChannel <- job
+			}(elements[i:end])
 		}
 		time.Sleep(time.Duration(interval) * time.Second) // wait for a job
 	}
@@ -161,45 +261,58 @@ func Process(record utils.Record) {
 	// Increment number of running jobs
 	WorkqueueMetrics.Jobs.Inc(1)
 
-	var docs []couchdb.CouchDoc
+	rname, spec := requestSpec(record)
+	// request which is already in WorkQueue is not split again, but its
+	// elements follow changes of request priority
+	if elements := RequestElements(rname)[rname]; len(elements) > 0 {
+		if v, ok := spec["RequestPriority"]; ok {
+			UpdatePriority(rname, intValue(v), elements)
+		}
+		return
+	}
 	reqConfig := requestConfig(record)
 	rType := requestType(reqConfig)
-	switch rType {
-	case "MonteCarlo":
-		policy := MonteCarloPolicy{Name: "MonteCarlo", Record: record, Config: reqConfig}
-		docs = policy.Split()
-	case "ResubmitBlock":
-		policy := ResubmitBlockPolicy{Name: "ResubmitBlock", Record: record, Config: reqConfig}
-		docs = policy.Split()
-	default:
-		policy := BlockPolicy{Name: "Block", Record: record, Config: reqConfig}
-		docs = policy.Split()
+	policy, err := NewPolicy(rType, record, reqConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"request": rname}).Warn("Request rejected: ", err)
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		logrus.WithFields(logrus.Fields{"request": rname, "policy": rType}).Warn("Request rejected: ", err)
+		return
+	}
+	elements, err := policy.Split()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"request": rname, "policy": rType}).Error("Unable to split request: ", err)
+		return
+	}
+	teams := requestTeams(spec)
+	for i := range elements {
+		if err := elements[i].SetStatus(Available); err != nil {
+			logrus.WithFields(logrus.Fields{"request": rname}).Error(err)
+			return
+		}
+		elements[i].Teams = teams
 	}
 	if utils.VERBOSE > 0 {
 		fmt.Println("### ReqMgr2 record", record)
 		fmt.Println("### WorkQueueElements ###")
-		for _, rec := range docs {
+		for _, rec := range elements {
 			fmt.Println(rec)
 		}
 	}
 	// if no results we do nothing
-	if len(docs) == 0 {
+	if len(elements) == 0 {
 		return
 	}
-	// insert WorkQueueElement records into CouchDB
-	resp, err := DB.Bulk(docs)
-	if utils.VERBOSE > 0 {
-		logrus.Info("Insert response: ", resp)
-	}
+	// insert WorkQueueElement records into the store
+	summary, err := Storage.Insert(elements)
+	fields := logrus.Fields{"request": rname, "inserted": len(summary.Inserted), "merged": len(summary.Merged), "failed": len(summary.Failed)}
 	if err != nil {
-		logrus.Warn("Insert bulk error: ", err, resp)
-		for _, doc := range docs {
-			if resp, err := DB.Post(doc); err != nil {
-				logrus.Warn("Insert Post error: ", err, resp)
-			}
-
-		}
+		logrus.WithFields(fields).Warn("Insert error: ", err)
+		return
 	}
+	logrus.WithFields(fields).Info("Request queued")
 }
 
 // helper function to get request config from record name
@@ -212,44 +325,100 @@ func requestConfig(record utils.Record) utils.Record {
 	return services.RequestConfig(name)
 }
 
-// helper function which returns request type from given record
+// helper function which returns start policy name from given record,
+// the start policy name of request config takes precedence over request
+// type, the Block policy is used when neither of them is a known policy
 func requestType(config utils.Record) string {
+	if v, ok := configValue(config, "policies.start.policyName").(string); ok && v != "" {
+		return v
+	}
 	for key, val := range config {
 		if strings.Contains(key, "requestType") {
-			return val.(string)
+			if v, ok := val.(string); ok && knownPolicy(v) {
+				return v
+			}
 		}
 	}
 	return "Block"
 }
 
-// Cleanup performs clean-up of WorkQueue
+// Cleanup performs clean-up of WorkQueue, the record holds request name and
+// its elements. The end policy of elements decides if request is finished,
+// in which case request status is propagated to ReqMgr2 and its elements are
+// deleted from WorkQueue. Priority changes of the request are propagated to
+// its queued elements. Elements of aborted and force-completed requests
+// are canceled and deleted once all of them are canceled, requests canceled
+// in WorkQueue are aborted in ReqMgr2 before their elements are deleted.
 func Cleanup(record utils.Record) {
 	// Decrement number of running jobs
 	WorkqueueMetrics.Jobs.Dec(1)
-	// NB: here three for loops is actually a one pass, since
-	// first for loop gets record name
-	// second for loop gets single document from reqMgr2 doc list
-	// third gets spec from reqMgr2 document
 	for rname, val := range record {
-		request := services.GetRequest(rname)
-		for _, req := range request { // reqMgr2 returns always a list
-			for _, spec := range req { // reqMgr2 record is {request_name: request_spec}
-				switch rec := spec.(type) {
-				case map[string]interface{}:
-					status, _ := rec["RequestStatus"].(string)
-					if status == "running-closed" {
-						v := val.(map[string]interface{})
-						id := v["_id"].(string)
-						rev := v["_rev"].(string)
-						doc := &couchdb.Document{ID: id, Rev: rev}
-						//                         DB.Delete(doc)
-						if _, err := DB.Delete(doc); err != nil {
-							msg := fmt.Sprintf("Unable to delete %s %s, %s", rname, status, err)
-							logrus.Warn(msg)
-						}
-					}
+		elements, ok := val.([]WorkQueueElement)
+		if !ok {
+			continue
+		}
+		spec := services.RequestSpec(rname)
+		if v, ok := spec["RequestPriority"]; ok {
+			UpdatePriority(rname, intValue(v), elements)
+		}
+		reqStatus, _ := spec["RequestStatus"].(string)
+		switch reqStatus {
+		case "aborted", "force-complete":
+			if n := cancelElements(elements); n > 0 {
+				logrus.WithFields(logrus.Fields{"request": rname, "elements": n}).Info("Cancel request")
+				continue // elements are changed, they will be checked in next cycle
+			}
+		}
+		if isCanceled(elements) {
+			if !requestStopped(reqStatus) {
+				if err := services.SetRequestStatus(rname, "aborted"); err != nil {
+					logrus.WithFields(logrus.Fields{"request": rname}).Warn("Unable to abort canceled request: ", err)
+					continue
+				}
+			}
+			for _, wqe := range elements {
+				if !wqe.InEndState() {
+					continue
+				}
+				if err := Storage.Delete(wqe); err != nil {
+					logrus.WithFields(logrus.Fields{"request": rname, "id": wqe.ID}).Warn("Unable to delete canceled element: ", err)
 				}
 			}
+			continue
 		}
+		// request open for new data is running until it is closed
+		if openForNewData(elements) {
+			continue
+		}
+		status, err := EvaluateEndPolicy(elements, services.RequestConfig(rname))
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"request": rname}).Warn("Unable to evaluate end policy: ", err)
+			continue
+		}
+		if status == RequestRunning {
+			continue
+		}
+		reqStatus = "completed"
+		if status == RequestFailed {
+			reqStatus = "failed"
+		}
+		if err := services.SetRequestStatus(rname, reqStatus); err != nil {
+			logrus.WithFields(logrus.Fields{"request": rname, "status": reqStatus}).Warn("Unable to update ReqMgr2 status: ", err)
+			continue
+		}
+		for _, wqe := range elements {
+			if err := Storage.Delete(wqe); err != nil {
+				msg := fmt.Sprintf("Unable to delete %s %s, %s", rname, status, err)
+				logrus.Warn(msg)
+			}
+		}
+	}
+}
+
+// UpdateLocations refreshes data location of WorkQueue elements, the record
+// holds batch of elements
+func UpdateLocations(record utils.Record) {
+	if elements, ok := record["elements"].([]WorkQueueElement); ok {
+		RefreshLocations(elements)
 	}
 }
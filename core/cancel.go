@@ -0,0 +1,87 @@
+package core
+
+// WorkQueue request cancellation implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
+)
+
+// CancelRequest cancels all elements of given request and aborts the request
+// in ReqMgr2, it returns number of elements which changed their status
+func CancelRequest(rname string) (int, error) {
+	if rname == "" {
+		return 0, fmt.Errorf("request name is not provided")
+	}
+	elements := RequestElements(rname)[rname]
+	if len(elements) == 0 {
+		return 0, fmt.Errorf("request %s is not in WorkQueue", rname)
+	}
+	ncanceled := cancelElements(elements)
+	if err := services.SetRequestStatus(rname, "aborted"); err != nil {
+		// request is aborted again before its canceled elements are deleted
+		log.WithFields(log.Fields{"request": rname}).Warn("Unable to abort request: ", err)
+	}
+	return ncanceled, nil
+}
+
+// helper function to cancel given elements. Elements which are not acquired
+// yet are canceled immediately, while acquired elements are marked as
+// CancelRequested and canceled once agent (or child queue) acknowledges it
+func cancelElements(elements []WorkQueueElement) int {
+	var ncanceled int
+	for _, wqe := range elements {
+		status := CancelRequested
+		if wqe.Status == Available {
+			status = Canceled
+		}
+		if wqe.Status == status || wqe.InEndState() || !ValidTransition(wqe.Status, status) {
+			continue
+		}
+		if err := wqe.SetStatus(status); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID}).Warn(err)
+			continue
+		}
+		if err := Storage.Update(&wqe); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID, "request": wqe.RequestName}).Warn("Unable to cancel element: ", err)
+			continue
+		}
+		ncanceled++
+	}
+	return ncanceled
+}
+
+// PendingCancellations returns ids of elements acquired by given agent (or
+// child queue) which cancellation is requested
+func PendingCancellations(agent string) []string {
+	var out []string
+	for _, wqe := range Storage.Query(CancelRequested, "") {
+		if wqe.ChildQueueUrl == agent {
+			out = append(out, wqe.ID)
+		}
+	}
+	return out
+}
+
+// helper function to check if any of the elements is canceled
+func isCanceled(elements []WorkQueueElement) bool {
+	for _, wqe := range elements {
+		if wqe.Status == CancelRequested || wqe.Status == Canceled {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to check if ReqMgr2 status of the request stops its work,
+// canceled elements of other requests would be queued again once deleted
+func requestStopped(status string) bool {
+	switch status {
+	case "aborted", "aborted-completed", "aborted-archived", "force-complete", "completed":
+		return true
+	}
+	return false
+}
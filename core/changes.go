@@ -0,0 +1,238 @@
+package core
+
+// WorkQueue CouchDB changes feed implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// Types of element events
+const (
+	ElementCreated       = "created" // element is inserted
+	ElementStatusChanged = "status"  // element changed its status
+	ElementDeleted       = "deleted" // element is deleted
+)
+
+// Event represents change of WorkQueue element
+type Event struct {
+	Type           string `json:"type"`           // event type
+	ID             string `json:"id"`             // element id
+	Request        string `json:"request"`        // request name of the element
+	Status         string `json:"status"`         // element status
+	PreviousStatus string `json:"previousStatus"` // element status before the change, if known
+	Seq            string `json:"seq"`            // sequence of the change in changes feed
+}
+
+// number of events buffered for every subscriber
+const eventsBuffer = 1000
+
+// subscribers of element events, {events channel: channel closed on unsubscribe}
+var subscribers = struct {
+	sync.RWMutex
+	channels map[chan Event]chan struct{}
+}{channels: make(map[chan Event]chan struct{})}
+
+// Subscribe returns channel which receives element events, events are not
+// dropped and publisher waits for subscriber which does not consume them
+// fast enough
+func Subscribe() chan Event {
+	ch := make(chan Event, eventsBuffer)
+	subscribers.Lock()
+	subscribers.channels[ch] = make(chan struct{})
+	subscribers.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivery of element events to given channel
+func Unsubscribe(ch chan Event) {
+	subscribers.Lock()
+	if quit, ok := subscribers.channels[ch]; ok {
+		close(quit)
+		delete(subscribers.channels, ch)
+	}
+	subscribers.Unlock()
+}
+
+// helper function to deliver event to all subscribers, it waits for busy
+// subscribers until they receive the event or unsubscribe
+func publish(event Event) {
+	subscribers.RLock()
+	channels := make(map[chan Event]chan struct{}, len(subscribers.channels))
+	for ch, quit := range subscribers.channels {
+		channels[ch] = quit
+	}
+	subscribers.RUnlock()
+	for ch, quit := range channels {
+		select {
+		case ch <- event:
+		case <-quit:
+		}
+	}
+}
+
+// ChangesWatcher follows changes feed of WorkQueue database and publishes
+// element events to subscribers. The last processed sequence is persisted in
+// checkpoint file once its events are delivered, and watcher resumes from it
+// after restart.
+type ChangesWatcher struct {
+	Url        string // WorkQueue database url
+	Checkpoint string // file to persist last processed sequence
+	Timeout    int64  // timeout (in sec) of long poll requests
+	since      string
+	elements   map[string]Event // last known state of elements which are not in end state
+}
+
+// NewChangesWatcher returns new instance of ChangesWatcher, watcher starts
+// from persisted checkpoint or from current state of the database
+func NewChangesWatcher(dbUrl, checkpoint string, timeout int64) *ChangesWatcher {
+	w := &ChangesWatcher{Url: strings.TrimRight(dbUrl, "/"), Checkpoint: checkpoint, Timeout: timeout, since: "now", elements: make(map[string]Event)}
+	if data, err := ioutil.ReadFile(checkpoint); err == nil && len(data) > 0 {
+		w.since = strings.TrimSpace(string(data))
+	}
+	return w
+}
+
+// Seed sets known state of given elements, e.g. elements stored in WorkQueue
+// when watcher starts, such that their status changes are published with
+// previous status
+func (w *ChangesWatcher) Seed(elements []WorkQueueElement) {
+	for _, wqe := range elements {
+		w.track(Event{ID: wqe.ID, Request: wqe.RequestName, Status: wqe.Status})
+	}
+}
+
+// Run function spawns watcher loop
+func (w *ChangesWatcher) Run() {
+	go func() {
+		for {
+			if err := w.Poll(); err != nil {
+				log.WithFields(log.Fields{"url": w.Url, "since": w.since}).Warn("Unable to get changes: ", err)
+				time.Sleep(time.Duration(w.Timeout) * time.Second)
+			}
+		}
+	}()
+}
+
+// changes feed response
+type changes struct {
+	Results []struct {
+		Seq     json.RawMessage `json:"seq"`
+		ID      string          `json:"id"`
+		Deleted bool            `json:"deleted"`
+		Doc     json.RawMessage `json:"doc"`
+	} `json:"results"`
+	LastSeq json.RawMessage `json:"last_seq"`
+}
+
+// helper function to convert sequence into string, CouchDB 1.x uses numbers
+// and CouchDB 2.x uses strings
+func seqString(seq json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(seq, &s); err == nil {
+		return s
+	}
+	return string(seq)
+}
+
+// Poll waits for next changes of WorkQueue database, publishes their events
+// and persists the checkpoint after events are delivered to subscribers
+func (w *ChangesWatcher) Poll() error {
+	rurl := fmt.Sprintf("%s/_changes?feed=longpoll&include_docs=true&timeout=%d&since=%s", w.Url, w.Timeout*1000, url.QueryEscape(w.since))
+	resp := utils.FetchResponse(rurl, "")
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("changes feed response: %s %s", resp.Status, string(resp.Data))
+	}
+	var rec changes
+	if err := json.Unmarshal(resp.Data, &rec); err != nil {
+		return err
+	}
+	for _, change := range rec.Results {
+		if strings.HasPrefix(change.ID, "_design/") {
+			continue
+		}
+		seq := seqString(change.Seq)
+		if change.Deleted {
+			w.deleted(change.ID, seq)
+			continue
+		}
+		var wqe WorkQueueElement
+		if err := json.Unmarshal(change.Doc, &wqe); err != nil || wqe.RequestName == "" {
+			continue
+		}
+		w.updated(wqe, seq)
+	}
+	if len(rec.LastSeq) > 0 {
+		w.since = seqString(rec.LastSeq)
+		return w.saveCheckpoint()
+	}
+	return nil
+}
+
+// helper function to publish event of deleted element
+func (w *ChangesWatcher) deleted(id, seq string) {
+	event := Event{Type: ElementDeleted, ID: id, Seq: seq}
+	if prev, ok := w.elements[id]; ok {
+		event.Request = prev.Request
+		event.PreviousStatus = prev.Status
+		delete(w.elements, id)
+	}
+	publish(event)
+}
+
+// helper function to publish event of created or updated element, updates
+// which do not change element status are not published. Updates of elements
+// with unknown previous status, e.g. elements changed while watcher was not
+// running, are only recorded.
+func (w *ChangesWatcher) updated(wqe WorkQueueElement, seq string) {
+	event := Event{Type: ElementStatusChanged, ID: wqe.ID, Request: wqe.RequestName, Status: wqe.Status, Seq: seq}
+	prev, known := w.elements[wqe.ID]
+	switch {
+	case strings.HasPrefix(wqe.Rev, "1-"):
+		event.Type = ElementCreated
+	case !known:
+		w.track(event)
+		return
+	case prev.Status == wqe.Status:
+		return
+	default:
+		event.PreviousStatus = prev.Status
+	}
+	w.track(event)
+	publish(event)
+}
+
+// helper function to record last known state of the element, elements in end
+// state do not change their status and they are not kept
+func (w *ChangesWatcher) track(event Event) {
+	if (WorkQueueElement{Status: event.Status}).InEndState() {
+		delete(w.elements, event.ID)
+		return
+	}
+	w.elements[event.ID] = event
+}
+
+// helper function to persist checkpoint, the file is replaced atomically
+func (w *ChangesWatcher) saveCheckpoint() error {
+	if w.Checkpoint == "" {
+		return nil
+	}
+	tmp := w.Checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(w.since), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.Checkpoint)
+}
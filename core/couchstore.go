@@ -0,0 +1,240 @@
+package core
+
+// WorkQueue CouchDB storage implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/segmentio/pointer"
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+	"github.com/zemirco/couchdb"
+)
+
+// CouchStore implements Store interface on top of CouchDB database
+type CouchStore struct {
+	DB  couchdb.DatabaseService
+	Url string // database url, bulk requests use it to read errors of failed rows
+}
+
+// bulkRow represents row of CouchDB bulk response
+type bulkRow struct {
+	ID     string `json:"id"`
+	Rev    string `json:"rev"`
+	Ok     bool   `json:"ok"`
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// NewCouchStore returns new instance of CouchStore
+func NewCouchStore(db couchdb.DatabaseService) *CouchStore {
+	return &CouchStore{DB: db}
+}
+
+// Insert method satisfy Store interface. Elements are inserted in bulk and
+// every row of bulk response is inspected: rows of elements which already
+// exist are resolved by merge, other failed rows are retried and their errors
+// are reported in summary.
+func (s *CouchStore) Insert(elements []WorkQueueElement) (InsertSummary, error) {
+	var summary InsertSummary
+	pending := make(map[string]int) // {id: index of element}
+	for i := range elements {
+		if elements[i].ID == "" {
+			elements[i].ID = ElementID(elements[i])
+		}
+		pending[elements[i].ID] = i
+	}
+	for attempt := 0; attempt < updateRetries && len(pending) > 0; attempt++ {
+		var docs []couchdb.CouchDoc
+		for _, i := range pending {
+			docs = append(docs, &elements[i])
+		}
+		resp, err := s.bulk(docs)
+		if err != nil {
+			// bulk request failed as a whole, stored elements are merged in next attempt
+			log.WithFields(log.Fields{"attempt": attempt, "elements": len(docs)}).Warn("Insert bulk error: ", err)
+			for id := range pending {
+				summary.fail(id, err)
+			}
+			continue
+		}
+		rowErrors := make(map[string]error) // {id: error of failed row}
+		for _, r := range resp {
+			i, ok := pending[r.ID]
+			if !ok {
+				continue
+			}
+			if r.Error != "" {
+				rowErrors[r.ID] = fmt.Errorf("%s: %s", r.Error, r.Reason)
+			}
+			if !r.Ok || r.Rev == "" {
+				continue
+			}
+			elements[i].Rev = r.Rev
+			summary.Inserted = append(summary.Inserted, r.ID)
+			delete(pending, r.ID)
+			delete(summary.Failed, r.ID)
+		}
+		// remaining rows failed, elements which already exist are merged
+		for id, i := range pending {
+			stored, err := s.Get(id)
+			if err != nil {
+				if e, ok := rowErrors[id]; ok {
+					err = e
+				}
+				summary.fail(id, err)
+				continue
+			}
+			merged, changed := mergeElement(stored, elements[i])
+			if changed {
+				if err := s.Update(&merged); err != nil {
+					summary.fail(id, err)
+					continue
+				}
+			}
+			elements[i] = merged
+			summary.Merged = append(summary.Merged, id)
+			delete(pending, id)
+			delete(summary.Failed, id)
+		}
+	}
+	return summary, summary.err()
+}
+
+// helper function to send bulk request, rows of CouchDB response are read
+// directly when database url is known since they carry errors of failed rows
+func (s *CouchStore) bulk(docs []couchdb.CouchDoc) ([]bulkRow, error) {
+	var rows []bulkRow
+	if s.Url == "" {
+		resp, err := s.DB.Bulk(docs)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp {
+			rows = append(rows, bulkRow{ID: r.ID, Rev: r.Rev, Ok: r.Ok})
+		}
+		return rows, nil
+	}
+	args, err := json.Marshal(map[string]interface{}{"docs": docs})
+	if err != nil {
+		return nil, err
+	}
+	resp := utils.FetchResponse(fmt.Sprintf("%s/_bulk_docs", s.Url), string(args))
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("bulk response: %s %s", resp.Status, string(resp.Data))
+	}
+	if err := json.Unmarshal(resp.Data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Get method satisfy Store interface
+func (s *CouchStore) Get(id string) (WorkQueueElement, error) {
+	var wqe WorkQueueElement
+	err := s.DB.Get(&wqe, id)
+	return wqe, couchError(err)
+}
+
+// Request method satisfy Store interface
+func (s *CouchStore) Request(rname string) []WorkQueueElement {
+	return s.view("elementsByWorkflow", rname)
+}
+
+// Query method satisfy Store interface
+func (s *CouchStore) Query(status, site string) []WorkQueueElement {
+	var elements []WorkQueueElement
+	switch {
+	case site != "":
+		elements = s.view("elementsBySite", site)
+	case status == Available:
+		elements = s.view("availableByPriority", "")
+	case status != "":
+		elements = s.view("elementsByStatus", status)
+	default:
+		elements = s.view("elementsByWorkflow", "")
+	}
+	var out []WorkQueueElement
+	for _, wqe := range elements {
+		if matchQuery(wqe, status, site) {
+			out = append(out, wqe)
+		}
+	}
+	sortByPriority(out)
+	return out
+}
+
+// Update method satisfy Store interface. CouchDB creates new document when
+// revision is not provided, such element is rejected as other stores do.
+func (s *CouchStore) Update(wqe *WorkQueueElement) error {
+	if wqe.Rev == "" {
+		if _, err := s.Get(wqe.ID); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	resp, err := s.DB.Put(wqe)
+	if err != nil {
+		return couchError(err)
+	}
+	wqe.Rev = resp.Rev
+	return nil
+}
+
+// Delete method satisfy Store interface
+func (s *CouchStore) Delete(wqe WorkQueueElement) error {
+	doc := &couchdb.Document{ID: wqe.ID, Rev: wqe.Rev}
+	_, err := s.DB.Delete(doc)
+	return couchError(err)
+}
+
+// helper function to map CouchDB errors to errors of Store interface
+func couchError(err error) error {
+	if e, ok := err.(*couchdb.Error); ok {
+		switch e.StatusCode {
+		case http.StatusConflict:
+			return ErrConflict
+		case http.StatusNotFound:
+			return ErrNotFound
+		}
+	}
+	return err
+}
+
+// helper function to get WorkQueue elements from given view, the view is
+// queried for given key or for all keys if key is empty
+func (s *CouchStore) view(viewName, key string) []WorkQueueElement {
+	params := couchdb.QueryParameters{
+		Reduce:      pointer.Bool(false),
+		IncludeDocs: pointer.Bool(true),
+	}
+	if key != "" {
+		params.Key = pointer.String(fmt.Sprintf("\"%s\"", key))
+	}
+	design := designName
+	var out []WorkQueueElement
+	res, err := s.DB.View(design).Get(viewName, params)
+	if err != nil {
+		log.WithFields(log.Fields{"view": fmt.Sprintf("%s/%s", design, viewName)}).Warn(err)
+		return out
+	}
+	for _, row := range res.Rows {
+		data, err := json.Marshal(row.Doc)
+		if err != nil {
+			continue
+		}
+		var wqe WorkQueueElement
+		if err := json.Unmarshal(data, &wqe); err != nil {
+			log.WithFields(log.Fields{"id": row.ID}).Warn("Unable to parse WorkQueueElement: ", err)
+			continue
+		}
+		out = append(out, wqe)
+	}
+	return out
+}
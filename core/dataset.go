@@ -0,0 +1,107 @@
+package core
+
+// WorkQueue Dataset policy implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+func init() {
+	RegisterPolicy("Dataset", func(record, config utils.Record) Policy {
+		return &DatasetPolicy{Name: "Dataset", Record: record, Config: config}
+	})
+}
+
+// DatasetPolicy defines dataset policy
+type DatasetPolicy struct {
+	Name   string
+	Record utils.Record
+	Config utils.Record
+}
+
+// Split method satisfy Policy interface
+// Dataset request produces single element per input dataset, the element
+// can run at sites which hold all blocks of the dataset
+func (b *DatasetPolicy) Split() ([]WorkQueueElement, error) {
+	var out []WorkQueueElement
+	var sPolicy, ePolicy string
+	v1 := configValue(b.Config, "policies.start.policyName")
+	if v1 != nil {
+		sPolicy = v1.(string)
+	}
+	v2 := configValue(b.Config, "policies.end.policyName")
+	if v2 != nil {
+		ePolicy = v2.(string)
+	}
+	for rname, spec := range b.Record { // reqMgr2 record is {request_name: request_spec}
+		if InWorkQueue(rname) {
+			break
+		}
+		switch rec := spec.(type) {
+		case map[string]interface{}:
+			requestName, _ := rec["RequestName"].(string)
+			if rname != requestName {
+				log.Warnf("ReqMgr2 rname=%s != RequestName=%s", rname, requestName)
+			}
+			dbs, _ := rec["DbsUrl"].(string)
+			wmSpec, _ := rec["RequestWorkflow"].(string)
+			priority := intValue(rec["RequestPriority"])
+			inputDataset, _ := specValue(rec, "InputDataset").(string)
+			if inputDataset == "" {
+				return out, errors.New("Dataset policy: request does not specify InputDataset")
+			}
+			blocks := services.Blocks(inputDataset)
+			maskedBlocks := services.MaskedBlocks(blocks)
+			if len(maskedBlocks) == 0 {
+				continue
+			}
+			var numberOfLumis, numberOfFiles, numberOfEvents int
+			var mblocks []string
+			for _, mb := range maskedBlocks {
+				numberOfLumis += mb.NumberOfLumis()
+				numberOfFiles += mb.NumberOfFiles()
+				numberOfEvents += mb.NumberOfEvents()
+				mblocks = append(mblocks, mb.Block)
+			}
+			// dataset is available at sites which hold all of its blocks
+			sites := commonSites(mblocks, services.Blocks2Sites(mblocks))
+			wqe := WorkQueueElement{
+				Inputs:         map[string][]string{inputDataset: sites},
+				NumberOfLumis:  numberOfLumis,
+				NumberOfFiles:  numberOfFiles,
+				NumberOfEvents: numberOfEvents,
+				Jobs:           NewSplitting(rec, b.Config).Jobs(numberOfFiles, numberOfLumis, numberOfEvents),
+				BlowupFactor:   BlowupFactor(rec),
+				WMSpec:         wmSpec,
+				Dbs:            dbs,
+				TaskName:       requestName,
+				RequestName:    requestName,
+				SiteWhiteList:  stringList(rec["SiteWhitelist"]),
+				SiteBlackList:  stringList(rec["SiteBlacklist"]),
+				Priority:       priority,
+				StartPolicy:    sPolicy,
+				EndPolicy:      ePolicy,
+			}
+			wqe.checkLocation()
+			out = append(out, wqe)
+		}
+	}
+	return out, nil
+}
+
+// Validate method satisfy Policy interface
+func (b *DatasetPolicy) Validate() error {
+	_, rec := requestSpec(b.Record)
+	if rec == nil {
+		return errors.New("Dataset policy: no request spec in ReqMgr2 record")
+	}
+	if v, _ := specValue(rec, "InputDataset").(string); v == "" {
+		return errors.New("Dataset policy: request does not specify InputDataset")
+	}
+	return nil
+}
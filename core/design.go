@@ -0,0 +1,102 @@
+package core
+
+// WorkQueue CouchDB design documents
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zemirco/couchdb"
+)
+
+// DesignVersion defines version of WorkQueue design document shipped with
+// the server, it should be increased whenever its views are changed
+const DesignVersion = 1
+
+// name of WorkQueue design document
+const designName = "WorkQueue"
+
+// Design represents versioned CouchDB design document
+type Design struct {
+	couchdb.Document
+	Language string                                `json:"language"`
+	Version  int                                   `json:"version"`
+	Views    map[string]couchdb.DesignDocumentView `json:"views"`
+}
+
+// WorkQueueDesign returns WorkQueue design document with views used by the server
+func WorkQueueDesign() Design {
+	views := map[string]couchdb.DesignDocumentView{
+		"elementsByWorkflow": {
+			Map:    `function(doc) { if (doc.RequestName) { emit(doc.RequestName, null); } }`,
+			Reduce: "_count",
+		},
+		"elementsByStatus": {
+			Map:    `function(doc) { if (doc.RequestName) { emit(doc.Status, null); } }`,
+			Reduce: "_count",
+		},
+		"elementsBySite": {
+			Map: `function(doc) {
+	if (!doc.RequestName) { return; }
+	var sites = {};
+	var inputs = doc.NoInputUpdate ? {} : (doc.Inputs || {});
+	for (var input in inputs) {
+		inputs[input].forEach(function(site) { sites[site] = true; });
+	}
+	if (Object.keys(sites).length === 0) {
+		(doc.SiteWhiteList || []).forEach(function(site) { sites[site] = true; });
+	}
+	(doc.SiteBlackList || []).forEach(function(site) { delete sites[site]; });
+	for (var site in sites) { emit(site, null); }
+}`,
+		},
+		"availableByPriority": {
+			Map: `function(doc) {
+	if (doc.RequestName && doc.Status === "Available") {
+		emit([-doc.Priority, (doc.Timestamps || {}).Available || 0], null);
+	}
+}`,
+		},
+		"requestTotals": {
+			Map: `function(doc) {
+	if (doc.RequestName) {
+		emit(doc.RequestName, {"Elements": 1, "Jobs": doc.Jobs || 0, "JobsDone": doc.JobsDone || 0, "JobsFailed": doc.JobsFailed || 0, "FilesProcessed": doc.FilesProcessed || 0, "EventsWritten": doc.EventsWritten || 0});
+	}
+}`,
+			Reduce: "_sum",
+		},
+	}
+	return Design{
+		Document: couchdb.Document{ID: "_design/" + designName},
+		Language: "javascript",
+		Version:  DesignVersion,
+		Views:    views,
+	}
+}
+
+// InstallDesign installs WorkQueue design document into given database or
+// upgrades installed design document of older version. It returns an error
+// if installed design document has newer version than the server supports.
+func InstallDesign(db couchdb.DatabaseService) error {
+	design := WorkQueueDesign()
+	var installed Design
+	if err := db.Get(&installed, design.ID); err != nil {
+		if couchError(err) != ErrNotFound {
+			return err
+		}
+	} else {
+		switch {
+		case installed.Version == design.Version:
+			return nil
+		case installed.Version > design.Version:
+			return fmt.Errorf("installed design document version %d is newer than supported version %d", installed.Version, design.Version)
+		}
+		design.Rev = installed.Rev
+	}
+	if _, err := db.Put(&design); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"design": design.ID, "version": design.Version, "previous": installed.Version}).Info("Install design document")
+	return nil
+}
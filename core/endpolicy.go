@@ -0,0 +1,111 @@
+package core
+
+// WorkQueue end policy implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// request states decided by end policy
+const (
+	RequestRunning = "Running"
+	RequestDone    = "Done"
+	RequestFailed  = "Failed"
+)
+
+// default fraction of successful work required by SingleShot policy
+const defaultSuccessThreshold = 0.9
+
+// EndPolicy interface defines end policy methods
+type EndPolicy interface {
+	Evaluate(elements []WorkQueueElement) string
+}
+
+// EndPolicyConstructor creates an end policy for given request config
+type EndPolicyConstructor func(config utils.Record) EndPolicy
+
+// registry of end policies
+var endPolicies = struct {
+	sync.RWMutex
+	constructors map[string]EndPolicyConstructor
+}{constructors: make(map[string]EndPolicyConstructor)}
+
+func init() {
+	RegisterEndPolicy("SingleShot", func(config utils.Record) EndPolicy {
+		threshold := floatValue(configValue(config, "policies.end.SuccessThreshold"))
+		if threshold <= 0 {
+			threshold = defaultSuccessThreshold
+		}
+		return &SingleShotPolicy{SuccessThreshold: threshold}
+	})
+}
+
+// RegisterEndPolicy registers end policy constructor under given name
+func RegisterEndPolicy(name string, constructor EndPolicyConstructor) {
+	endPolicies.Lock()
+	defer endPolicies.Unlock()
+	endPolicies.constructors[name] = constructor
+}
+
+// NewEndPolicy creates end policy registered under given name
+func NewEndPolicy(name string, config utils.Record) (EndPolicy, error) {
+	endPolicies.RLock()
+	constructor, ok := endPolicies.constructors[name]
+	endPolicies.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown end policy %s", name)
+	}
+	return constructor(config), nil
+}
+
+// SingleShotPolicy defines end policy which evaluates request once all of
+// its elements are complete or in their final state, the request is Done if
+// fraction of successful work reaches SuccessThreshold and Failed otherwise
+type SingleShotPolicy struct {
+	SuccessThreshold float64
+}
+
+// Evaluate method satisfy EndPolicy interface
+func (p *SingleShotPolicy) Evaluate(elements []WorkQueueElement) string {
+	if len(elements) == 0 {
+		return RequestRunning
+	}
+	// every element is weighted by its number of jobs
+	var total, success float64
+	for _, e := range elements {
+		if !e.InEndState() && e.PercentComplete < 100 {
+			return RequestRunning
+		}
+		weight := float64(e.Jobs)
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		success += weight * float64(e.PercentSuccess) / 100
+	}
+	if success/total >= p.SuccessThreshold {
+		return RequestDone
+	}
+	return RequestFailed
+}
+
+// EvaluateEndPolicy decides status of the request from all of its elements
+// using end policy of the elements and given request config
+func EvaluateEndPolicy(elements []WorkQueueElement, config utils.Record) (string, error) {
+	if len(elements) == 0 {
+		return RequestRunning, nil
+	}
+	name := elements[0].EndPolicy
+	if name == "" {
+		name = "SingleShot"
+	}
+	policy, err := NewEndPolicy(name, config)
+	if err != nil {
+		return RequestRunning, err
+	}
+	return policy.Evaluate(elements), nil
+}
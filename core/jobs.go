@@ -0,0 +1,118 @@
+package core
+
+// WorkQueue job estimation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// Splitting defines job splitting algorithm and parameters of the request
+type Splitting struct {
+	Algorithm    string
+	EventsPerJob int
+	LumisPerJob  int
+	FilesPerJob  int
+}
+
+// NewSplitting creates Splitting from ReqMgr2 request spec and request config,
+// values of request spec take precedence over request config
+func NewSplitting(rec map[string]interface{}, config utils.Record) Splitting {
+	s := Splitting{}
+	if v, ok := specValue(rec, "SplittingAlgo").(string); ok {
+		s.Algorithm = v
+	} else if v, ok := configValue(config, "splitting.algorithm").(string); ok {
+		s.Algorithm = v
+	}
+	s.EventsPerJob = intValue(specValue(rec, "EventsPerJob"))
+	if s.EventsPerJob <= 0 {
+		s.EventsPerJob = intValue(configValue(config, "splitting.events_per_job"))
+	}
+	s.LumisPerJob = intValue(specValue(rec, "LumisPerJob"))
+	if s.LumisPerJob <= 0 {
+		s.LumisPerJob = intValue(configValue(config, "splitting.lumis_per_job"))
+	}
+	s.FilesPerJob = intValue(specValue(rec, "FilesPerJob"))
+	if s.FilesPerJob <= 0 {
+		s.FilesPerJob = intValue(configValue(config, "splitting.files_per_job"))
+	}
+	return s
+}
+
+// String implements Stringer interface
+func (s Splitting) String() string {
+	return fmt.Sprintf("{Algorithm: %s, EventsPerJob: %d, LumisPerJob: %d, FilesPerJob: %d}", s.Algorithm, s.EventsPerJob, s.LumisPerJob, s.FilesPerJob)
+}
+
+// Jobs estimates number of jobs for given amount of input data
+func (s Splitting) Jobs(files, lumis, events int) int {
+	if files == 0 && lumis == 0 && events == 0 {
+		return 0
+	}
+	var jobs int
+	switch s.Algorithm {
+	case "EventBased":
+		jobs = ceilDiv(events, s.EventsPerJob)
+	case "LumiBased":
+		jobs = ceilDiv(lumis, s.LumisPerJob)
+	case "FileBased":
+		jobs = ceilDiv(files, s.FilesPerJob)
+	case "EventAwareLumiBased":
+		// jobs contain whole lumis with up to EventsPerJob events
+		jobs = ceilDiv(events, s.EventsPerJob)
+		if jobs > lumis {
+			jobs = lumis
+		}
+	default:
+		jobs = files
+	}
+	if jobs <= 0 { // missing splitting parameters, fallback to a job per file
+		jobs = files
+	}
+	if jobs <= 0 {
+		jobs = 1
+	}
+	return jobs
+}
+
+// BlowupFactor returns ratio of total number of jobs to number of jobs
+// of the first task of the request. For TaskChain requests every task
+// produces its own jobs which number scales with ratio of events per job
+// of the task and its predecessor.
+func BlowupFactor(rec map[string]interface{}) float64 {
+	if v := floatValue(rec["BlowupFactor"]); v > 0 {
+		return v
+	}
+	ntasks := intValue(rec["TaskChain"])
+	if ntasks <= 1 {
+		return 1
+	}
+	factor, multiplier := 1.0, 1.0
+	prevEvents := intValue(specValue(rec, "EventsPerJob"))
+	for i := 2; i <= ntasks; i++ {
+		task, ok := rec[fmt.Sprintf("Task%d", i)].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		events := intValue(task["EventsPerJob"])
+		if prevEvents > 0 && events > 0 {
+			multiplier *= float64(prevEvents) / float64(events)
+		}
+		if events > 0 {
+			prevEvents = events
+		}
+		factor += multiplier
+	}
+	return factor
+}
+
+// TotalJobs returns number of jobs element will produce across all tasks
+func (w WorkQueueElement) TotalJobs() int {
+	if w.BlowupFactor <= 0 {
+		return w.Jobs
+	}
+	return int(math.Ceil(float64(w.Jobs) * w.BlowupFactor))
+}
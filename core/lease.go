@@ -0,0 +1,127 @@
+package core
+
+// WorkQueue element lease implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// LeaseDuration defines time (in sec) agent holds acquired element without
+// heartbeat or progress report
+var LeaseDuration int64 = 3600
+
+// MaxRetries defines number of times element with expired lease is returned
+// to Available state before it is failed
+var MaxRetries = 3
+
+// states of elements which are leased by an agent
+var leasedStates = []string{Negotiating, Acquired, Running, CancelRequested}
+
+// RenewLease extends lease of the element by LeaseDuration
+func (w *WorkQueueElement) RenewLease() {
+	w.LeaseExpire = time.Now().Unix() + LeaseDuration
+}
+
+// LeaseExpired returns true if element is leased by an agent and its lease
+// expired at given time
+func (w WorkQueueElement) LeaseExpired(now int64) bool {
+	if w.LeaseExpire == 0 {
+		return false
+	}
+	for _, status := range leasedStates {
+		if w.Status == status {
+			return w.LeaseExpire < now
+		}
+	}
+	return false
+}
+
+// Requeue releases element with expired lease, the element becomes Available
+// for other agents or Failed when it exceeds given number of retries.
+// Element which cancellation was requested is canceled.
+func (w *WorkQueueElement) Requeue(maxRetries int) error {
+	status := Available
+	switch {
+	case w.Status == CancelRequested:
+		status = Canceled
+	case w.Retries >= maxRetries:
+		status = Failed
+	}
+	if err := w.SetStatus(status); err != nil {
+		return err
+	}
+	if status == Available {
+		w.Retries++
+		w.ChildQueueUrl = ""
+	}
+	w.LeaseExpire = 0
+	return nil
+}
+
+// Heartbeat renews leases of elements acquired by given agent, it returns
+// number of renewed elements
+func Heartbeat(agent string) (int, error) {
+	if agent == "" {
+		return 0, errors.New("heartbeat does not specify agent")
+	}
+	var renewed int
+	for _, status := range leasedStates {
+		for _, wqe := range Storage.Query(status, "") {
+			if wqe.ChildQueueUrl != agent {
+				continue
+			}
+			wqe.RenewLease()
+			if err := Storage.Update(&wqe); err != nil {
+				log.WithFields(log.Fields{"id": wqe.ID, "agent": agent}).Warn("Unable to renew lease: ", err)
+				continue
+			}
+			renewed++
+		}
+	}
+	return renewed, nil
+}
+
+// helper function to return elements with expired lease
+func expiredElements() []WorkQueueElement {
+	var out []WorkQueueElement
+	now := time.Now().Unix()
+	for _, status := range leasedStates {
+		for _, wqe := range Storage.Query(status, "") {
+			if wqe.LeaseExpired(now) {
+				out = append(out, wqe)
+			}
+		}
+	}
+	return out
+}
+
+// ExpireLeases requeues elements with expired lease, the record holds
+// batch of elements
+func ExpireLeases(record utils.Record) {
+	elements, ok := record["elements"].([]WorkQueueElement)
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	for _, wqe := range elements {
+		if !wqe.LeaseExpired(now) {
+			continue
+		}
+		agent := wqe.ChildQueueUrl
+		if err := wqe.Requeue(MaxRetries); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID, "agent": agent}).Warn(err)
+			continue
+		}
+		// element renewed concurrently by the agent is rejected with a conflict
+		if err := Storage.Update(&wqe); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID, "agent": agent}).Info("Unable to requeue element: ", err)
+			continue
+		}
+		log.WithFields(log.Fields{"id": wqe.ID, "request": wqe.RequestName, "agent": agent, "status": wqe.Status, "retries": wqe.Retries}).Warn("Element lease expired")
+	}
+}
@@ -0,0 +1,270 @@
+package core
+
+// WorkQueue local queue implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// LocalQueue defines local WorkQueue which pulls work from its parent (global) queue
+type LocalQueue struct {
+	ParentUrl string         // url of parent WorkQueue
+	Url       string         // url of this WorkQueue known to the parent
+	Team      string         // team name of agents served by this WorkQueue
+	Sites     map[string]int // {site: number of job slots}
+	Interval  int64          // interval (in sec) to pull work and report progress
+}
+
+// RunLocal function spawns local queue loop which pulls work from parent
+// queue, reports progress back to it and requeues local elements with
+// expired lease
+func (d *Dispatcher) RunLocal(lq LocalQueue) {
+	go func() {
+		for {
+			if err := PullWork(lq); err != nil {
+				log.WithFields(log.Fields{"parent": lq.ParentUrl}).Warn("Unable to pull work: ", err)
+			}
+			if err := ReportProgress(lq); err != nil {
+				log.WithFields(log.Fields{"parent": lq.ParentUrl}).Warn("Unable to report progress: ", err)
+			}
+			// requeue local elements of agents which lost their lease
+			ExpireLeases(utils.Record{"elements": expiredElements()})
+			time.Sleep(time.Duration(lq.Interval) * time.Second)
+		}
+	}()
+}
+
+// PullWork acquires elements from parent queue for free slots of local queue
+// sites, splits them into local elements and inserts them into WorkQueue
+func PullWork(lq LocalQueue) error {
+	// free slots are slots not occupied by local elements waiting for agents
+	slots := make(map[string]int)
+	for site, n := range lq.Sites {
+		slots[site] = n
+	}
+	for _, wqe := range Storage.Query(Available, "") {
+		if site := matchSite(wqe, slots); site != "" {
+			slots[site] -= wqe.TotalJobs()
+		}
+	}
+	if len(freeSites(slots)) == 0 {
+		return nil
+	}
+	req := WorkRequest{Agent: lq.Url, Team: lq.Team, Sites: slots}
+	args, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp := utils.FetchResponse(fmt.Sprintf("%s/getWork", lq.ParentUrl), string(args))
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("parent queue response: %s %s", resp.Status, string(resp.Data))
+	}
+	var elements []WorkQueueElement
+	if err := json.Unmarshal(resp.Data, &elements); err != nil {
+		return err
+	}
+	var local []WorkQueueElement
+	for _, gwqe := range elements {
+		local = append(local, splitLocally(gwqe, lq.ParentUrl)...)
+	}
+	if len(local) == 0 {
+		return nil
+	}
+	summary, err := Storage.Insert(local)
+	for id, reason := range summary.Failed {
+		// parent element stays acquired until its lease expires
+		log.WithFields(log.Fields{"parent": lq.ParentUrl, "id": id}).Warn("Unable to insert local element: ", reason)
+	}
+	return err
+}
+
+// helper function to split element of parent queue into local elements,
+// element with many input blocks (or input dataset) is split per block.
+// Blocks of input dataset are selected by block and run/lumi lists of the
+// request, and element is kept as is if request spec is not available.
+func splitLocally(gwqe WorkQueueElement, parentUrl string) []WorkQueueElement {
+	var out []WorkQueueElement
+	var blocks, datasets []string
+	for input := range gwqe.Inputs {
+		if strings.Contains(input, "#") || strings.HasPrefix(input, "acdc:") {
+			blocks = append(blocks, input)
+		} else { // input dataset
+			datasets = append(datasets, input)
+		}
+	}
+	var filter RunLumiFilter
+	if len(datasets) > 0 {
+		spec := services.RequestSpec(gwqe.RequestName)
+		if spec == nil {
+			log.WithFields(log.Fields{"request": gwqe.RequestName, "id": gwqe.ID}).Warn("No request spec, element is not split")
+			out = append(out, localElement(gwqe, parentUrl))
+			return out
+		}
+		filter = NewRunLumiFilter(spec)
+		for _, dataset := range datasets {
+			blocks = append(blocks, filter.Blocks(services.Blocks(dataset))...)
+		}
+	}
+	// elements with single input or with lumi mask are kept as is
+	if len(blocks) <= 1 || len(gwqe.Mask.RunAndLumis) > 0 {
+		out = append(out, localElement(gwqe, parentUrl))
+		return out
+	}
+	maskedBlocks := services.MaskedBlocks(blocks)
+	blockSites := services.Blocks2Sites(blocks)
+	for _, block := range maskedBlocks {
+		mb := filter.Apply(block)
+		if mb.NumberOfLumis() == 0 {
+			continue
+		}
+		wqe := localElement(gwqe, parentUrl)
+		wqe.Inputs = map[string][]string{mb.Block: blockSites[mb.Block]}
+		if filter.Active() {
+			addRunLumis(&wqe.Mask, mb)
+		}
+		wqe.NumberOfFiles = mb.NumberOfFiles()
+		wqe.NumberOfLumis = mb.NumberOfLumis()
+		wqe.NumberOfEvents = mb.NumberOfEvents()
+		// jobs of parent element are distributed proportionally to block lumis
+		if gwqe.NumberOfLumis > 0 {
+			wqe.Jobs = int(math.Ceil(float64(gwqe.Jobs) * float64(wqe.NumberOfLumis) / float64(gwqe.NumberOfLumis)))
+		}
+		wqe.checkLocation()
+		out = append(out, wqe)
+	}
+	if len(out) == 0 { // no block details, keep element as is
+		out = append(out, localElement(gwqe, parentUrl))
+	}
+	return out
+}
+
+// helper function to create local element from element of parent queue
+func localElement(gwqe WorkQueueElement, parentUrl string) WorkQueueElement {
+	wqe := gwqe
+	wqe.ID = ""
+	wqe.Rev = ""
+	wqe.Status = ""
+	wqe.Timestamps = nil
+	wqe.ParentQueueUrl = parentUrl
+	wqe.ParentQueueId = gwqe.ID
+	wqe.ChildQueueUrl = ""
+	wqe.LeaseExpire = 0
+	wqe.Retries = 0
+	wqe.PercentComplete = 0
+	wqe.PercentSuccess = 0
+	wqe.SetStatus(Available)
+	return wqe
+}
+
+// ReportProgress aggregates progress of local elements per element of parent
+// queue and reports it to the parent, local elements are deleted once their
+// parent element reached its final state
+func ReportProgress(lq LocalQueue) error {
+	groups := make(map[string][]WorkQueueElement)
+	for _, elements := range RequestElements("") {
+		for _, wqe := range elements {
+			if wqe.ParentQueueId != "" {
+				groups[wqe.ParentQueueId] = append(groups[wqe.ParentQueueId], wqe)
+			}
+		}
+	}
+	var reports []ElementProgress
+	for pid, elements := range groups {
+		reports = append(reports, aggregateProgress(pid, lq.Url, elements))
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+	args, err := json.Marshal(reports)
+	if err != nil {
+		return err
+	}
+	resp := utils.FetchResponse(fmt.Sprintf("%s/progress", lq.ParentUrl), string(args))
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("parent queue response: %s %s", resp.Status, string(resp.Data))
+	}
+	// parent queue responds with rejected reports, ids of reported elements
+	// which it doesn't have and ids of its elements which should be canceled
+	var rec ProgressResponse
+	if err := json.Unmarshal(resp.Data, &rec); err != nil {
+		return err
+	}
+	for pid, reason := range rec.Rejected {
+		log.WithFields(log.Fields{"parent": lq.ParentUrl, "id": pid}).Warn("Parent queue rejected progress report: ", reason)
+	}
+	for _, pid := range rec.Cancel {
+		if n := cancelElements(groups[pid]); n > 0 {
+			log.WithFields(log.Fields{"parent": lq.ParentUrl, "id": pid, "elements": n}).Info("Cancel local elements")
+		}
+	}
+	// local elements of rejected reports are kept and reported again, while
+	// reports of elements deleted in parent queue are not repeated
+	for _, report := range reports {
+		if _, ok := rec.Rejected[report.ID]; ok || !(WorkQueueElement{Status: report.Status}).InEndState() {
+			continue
+		}
+		for _, wqe := range groups[report.ID] {
+			if err := Storage.Delete(wqe); err != nil {
+				log.WithFields(log.Fields{"id": wqe.ID}).Warn("Unable to delete local element: ", err)
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to aggregate progress of local elements of parent element
+func aggregateProgress(pid, agent string, elements []WorkQueueElement) ElementProgress {
+	p := AggregateProgress(elements)
+	report := ElementProgress{
+		ID:              pid,
+		Agent:           agent,
+		Status:          Acquired,
+		PercentComplete: p.PercentComplete,
+		PercentSuccess:  p.PercentSuccess,
+		JobsDone:        p.JobsDone,
+		JobsFailed:      p.JobsFailed,
+		FilesProcessed:  p.FilesProcessed,
+		EventsWritten:   p.EventsWritten,
+	}
+	nend, nfailed, ncanceled, nrunning := 0, 0, 0, 0
+	for _, wqe := range elements {
+		switch {
+		case wqe.InEndState():
+			nend++
+			if wqe.Status == Failed {
+				nfailed++
+			}
+			if wqe.Status == Canceled {
+				ncanceled++
+			}
+		case wqe.Status == Acquired || wqe.Status == Running:
+			nrunning++
+		}
+	}
+	switch {
+	case nend == len(elements) && ncanceled > 0:
+		report.Status = Canceled
+	case nend == len(elements) && nfailed > 0:
+		report.Status = Failed
+	case nend == len(elements):
+		report.Status = Done
+	case nrunning > 0 || nend > 0:
+		report.Status = Running
+	}
+	return report
+}
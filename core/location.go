@@ -0,0 +1,227 @@
+package core
+
+// WorkQueue data location implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// number of elements which locations are refreshed by single location job
+const locationBatchSize = 100
+
+// helper function to return pileup datasets of the request and their
+// locations, pileup dataset is available at sites which hold all of its blocks
+func pileupLocations(rec map[string]interface{}) map[string][]string {
+	var datasets []string
+	for _, key := range []string{"MCPileup", "DataPileup"} {
+		datasets = append(datasets, stringList(specValue(rec, key))...)
+	}
+	if len(datasets) == 0 {
+		return nil
+	}
+	var blocks []string
+	datasetBlocks := make(map[string][]string)
+	for _, dataset := range utils.List2Set(datasets) {
+		datasetBlocks[dataset] = services.Blocks(dataset)
+		blocks = append(blocks, datasetBlocks[dataset]...)
+	}
+	blockSites := services.Blocks2Sites(utils.List2Set(blocks))
+	out := make(map[string][]string)
+	for dataset, dblocks := range datasetBlocks {
+		out[dataset] = commonSites(dblocks, blockSites)
+	}
+	return out
+}
+
+// helper function to return boolean flag of the request
+func boolValue(rec map[string]interface{}, key string) bool {
+	switch v := specValue(rec, key).(type) {
+	case bool:
+		return v
+	case string:
+		return v == "True" || v == "true"
+	}
+	return false
+}
+
+// PossibleSites returns list of sites where element can run, i.e. sites which
+// hold input, parent and pileup data of the element. Input and parent
+// locations are ignored if NoInputUpdate (TrustSitelists) is set, and pileup
+// locations are ignored if NoPileupUpdate (TrustPUSitelists) is set, in which
+// case the sites are taken from the request site white list. Finally, the
+// sites are restricted by site white and black lists of the request.
+func (w WorkQueueElement) PossibleSites() []string {
+	var sites []string
+	restricted := false
+	restrict := func(s []string) {
+		if !restricted {
+			sites = utils.List2Set(s)
+			restricted = true
+		} else {
+			sites = utils.Intersect(sites, s)
+		}
+	}
+	if !w.NoInputUpdate {
+		for _, s := range w.Inputs {
+			restrict(s)
+		}
+		if w.ParentFlag {
+			for _, s := range w.ParentData {
+				restrict(s)
+			}
+		}
+	}
+	if !w.NoPileupUpdate {
+		for _, s := range w.PileupData {
+			restrict(s)
+		}
+	}
+	if !restricted {
+		sites = utils.List2Set(w.SiteWhiteList)
+	} else if len(w.SiteWhiteList) > 0 {
+		sites = utils.Intersect(sites, w.SiteWhiteList)
+	}
+	var out []string
+	for _, site := range sites {
+		if !utils.InList(site, w.SiteBlackList) {
+			out = append(out, site)
+		}
+	}
+	return out
+}
+
+// helper function to flag element which can't run at any site
+func (w *WorkQueueElement) checkLocation() {
+	w.NoLocation = len(w.PossibleSites()) == 0
+	if w.NoLocation {
+		log.WithFields(log.Fields{"request": w.RequestName, "inputs": w.Inputs}).Warn("Element has no possible site to run")
+	}
+}
+
+// UpdateLocation updates sites of element input, parent and pileup data from
+// given locations, i.e. {block or dataset: sites}. Input and parent data are
+// not updated if NoInputUpdate is set, and pileup data is not updated if
+// NoPileupUpdate is set. Data without known location keeps its sites. It
+// returns true if any of the element sites were changed.
+func (w *WorkQueueElement) UpdateLocation(locations map[string][]string) bool {
+	changed := false
+	update := func(data map[string][]string) {
+		for name, sites := range data {
+			newSites, ok := locations[name]
+			if !ok || len(newSites) == 0 {
+				continue
+			}
+			newSites = utils.List2Set(newSites)
+			if !sameSites(sites, newSites) {
+				data[name] = newSites
+				changed = true
+			}
+		}
+	}
+	if !w.NoInputUpdate {
+		update(w.Inputs)
+		update(w.ParentData)
+	}
+	if !w.NoPileupUpdate {
+		update(w.PileupData)
+	}
+	if changed {
+		w.checkLocation()
+	}
+	return changed
+}
+
+// helper function to compare two site lists regardless of their order
+func sameSites(a, b []string) bool {
+	a = utils.List2Set(a)
+	b = utils.List2Set(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for _, site := range a {
+		if !utils.InList(site, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to return sites which hold all given blocks
+func commonSites(blocks []string, blockSites map[string][]string) []string {
+	var sites []string
+	for idx, blk := range blocks {
+		if idx == 0 {
+			sites = utils.List2Set(blockSites[blk])
+		} else {
+			sites = utils.Intersect(sites, blockSites[blk])
+		}
+	}
+	return sites
+}
+
+// RefreshLocations looks-up current locations of data used by Available
+// elements and writes back elements which sites were changed. It returns
+// number of updated elements.
+func RefreshLocations(elements []WorkQueueElement) int {
+	var blocks, datasets []string
+	for _, wqe := range elements {
+		if wqe.Status != Available {
+			continue
+		}
+		var data []map[string][]string
+		if !wqe.NoInputUpdate {
+			data = append(data, wqe.Inputs, wqe.ParentData)
+		}
+		if !wqe.NoPileupUpdate {
+			data = append(data, wqe.PileupData)
+		}
+		for _, d := range data {
+			for name := range d {
+				switch {
+				case strings.HasPrefix(name, "acdc:"): // ACDC locations come from its files
+				case strings.Contains(name, "#"):
+					blocks = append(blocks, name)
+				default:
+					datasets = append(datasets, name)
+				}
+			}
+		}
+	}
+	if len(blocks) == 0 && len(datasets) == 0 {
+		return 0
+	}
+	// dataset is available at sites which hold all of its blocks
+	datasetBlocks := make(map[string][]string)
+	for _, dataset := range utils.List2Set(datasets) {
+		datasetBlocks[dataset] = services.Blocks(dataset)
+		blocks = append(blocks, datasetBlocks[dataset]...)
+	}
+	locations := make(map[string][]string)
+	for block, sites := range services.Blocks2Sites(utils.List2Set(blocks)) {
+		locations[block] = sites
+	}
+	for dataset, dblocks := range datasetBlocks {
+		if len(dblocks) > 0 {
+			locations[dataset] = commonSites(dblocks, locations)
+		}
+	}
+	var changed []WorkQueueElement
+	for _, wqe := range elements {
+		if wqe.Status == Available && wqe.UpdateLocation(locations) {
+			changed = append(changed, wqe)
+		}
+	}
+	if len(changed) == 0 {
+		return 0
+	}
+	// elements modified concurrently are rejected with a conflict and their
+	// locations are refreshed in the next cycle
+	updated := updateElements(changed)
+	log.WithFields(log.Fields{"elements": updated}).Info("Update locations")
+	return updated
+}
@@ -0,0 +1,172 @@
+package core
+
+// WorkQueue run/lumi mask implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// RunLumiFilter defines selection of input data requested in ReqMgr2 request
+type RunLumiFilter struct {
+	RunWhitelist   []int
+	RunBlacklist   []int
+	BlockWhitelist []string
+	BlockBlacklist []string
+	LumiList       map[int][][]int // {run: [[firstLumi, lastLumi], ...]}
+}
+
+// NewRunLumiFilter creates RunLumiFilter from ReqMgr2 request spec
+func NewRunLumiFilter(rec map[string]interface{}) RunLumiFilter {
+	f := RunLumiFilter{
+		RunWhitelist:   intList(specValue(rec, "RunWhitelist")),
+		RunBlacklist:   intList(specValue(rec, "RunBlacklist")),
+		BlockWhitelist: stringList(specValue(rec, "BlockWhitelist")),
+		BlockBlacklist: stringList(specValue(rec, "BlockBlacklist")),
+	}
+	// ReqMgr2 LumiList is {"run": [[firstLumi, lastLumi], ...]}
+	if lumiList, ok := specValue(rec, "LumiList").(map[string]interface{}); ok && len(lumiList) > 0 {
+		f.LumiList = make(map[int][][]int)
+		for key, val := range lumiList {
+			run, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			ranges, _ := val.([]interface{})
+			for _, r := range ranges {
+				if pair := intList(r); len(pair) == 2 {
+					f.LumiList[run] = append(f.LumiList[run], pair)
+				}
+			}
+		}
+	}
+	return f
+}
+
+// Active returns true if filter selects subset of input data
+func (f RunLumiFilter) Active() bool {
+	return len(f.RunWhitelist) > 0 || len(f.RunBlacklist) > 0 || len(f.BlockWhitelist) > 0 || len(f.BlockBlacklist) > 0 || f.LumiList != nil
+}
+
+// Blocks returns list of blocks accepted by block white/black lists
+func (f RunLumiFilter) Blocks(blocks []string) []string {
+	var out []string
+	for _, blk := range blocks {
+		if len(f.BlockWhitelist) > 0 && !utils.InList(blk, f.BlockWhitelist) {
+			continue
+		}
+		if utils.InList(blk, f.BlockBlacklist) {
+			continue
+		}
+		out = append(out, blk)
+	}
+	return out
+}
+
+// AcceptRun returns true if given run is accepted by the filter
+func (f RunLumiFilter) AcceptRun(run int) bool {
+	if len(f.RunWhitelist) > 0 && !intInList(run, f.RunWhitelist) {
+		return false
+	}
+	if intInList(run, f.RunBlacklist) {
+		return false
+	}
+	if f.LumiList != nil {
+		if _, ok := f.LumiList[run]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// AcceptLumi returns true if given run and lumi are accepted by the filter
+func (f RunLumiFilter) AcceptLumi(run, lumi int) bool {
+	if !f.AcceptRun(run) {
+		return false
+	}
+	if f.LumiList == nil {
+		return true
+	}
+	for _, r := range f.LumiList[run] {
+		if lumi >= r[0] && lumi <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns masked block which contains only accepted files and lumis
+func (f RunLumiFilter) Apply(mb services.MaskedBlock) services.MaskedBlock {
+	out := services.MaskedBlock{Block: mb.Block}
+	for _, fl := range mb.FilesLumis {
+		run := int(fl.RunLumis.Run)
+		if !f.AcceptRun(run) {
+			continue
+		}
+		// events are aligned with lumis when DBS provides event counts per lumi,
+		// otherwise we keep events of the file if any of its lumis is selected
+		aligned := len(fl.RunLumis.Events) == len(fl.RunLumis.Lumis)
+		runLumis := services.RunLumis{Run: fl.RunLumis.Run}
+		for idx, lumi := range fl.RunLumis.Lumis {
+			if !f.AcceptLumi(run, int(lumi)) {
+				continue
+			}
+			runLumis.Lumis = append(runLumis.Lumis, lumi)
+			if aligned {
+				runLumis.Events = append(runLumis.Events, fl.RunLumis.Events[idx])
+			}
+		}
+		if len(runLumis.Lumis) == 0 {
+			continue
+		}
+		if !aligned {
+			runLumis.Events = fl.RunLumis.Events
+		}
+		out.FilesLumis = append(out.FilesLumis, services.FileLumis{Lfn: fl.Lfn, RunLumis: runLumis})
+	}
+	return out
+}
+
+// helper function to add run and lumis of masked block to given mask
+func addRunLumis(mask *Mask, mb services.MaskedBlock) {
+	if mask.RunAndLumis == nil {
+		mask.RunAndLumis = make(map[int][]int)
+	}
+	for _, fl := range mb.FilesLumis {
+		run := int(fl.RunLumis.Run)
+		for _, lumi := range fl.RunLumis.Lumis {
+			mask.RunAndLumis[run] = append(mask.RunAndLumis[run], int(lumi))
+		}
+		if mask.FirstRun == 0 || run < mask.FirstRun {
+			mask.FirstRun = run
+		}
+		if run > mask.LastRun {
+			mask.LastRun = run
+		}
+	}
+	// keep lumis of every run sorted and unique
+	for run, lumis := range mask.RunAndLumis {
+		sort.Ints(lumis)
+		var uniq []int
+		for idx, lumi := range lumis {
+			if idx == 0 || lumi != lumis[idx-1] {
+				uniq = append(uniq, lumi)
+			}
+		}
+		mask.RunAndLumis[run] = uniq
+	}
+}
+
+// helper function to check if integer is in a list
+func intInList(a int, list []int) bool {
+	for _, b := range list {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
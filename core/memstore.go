@@ -0,0 +1,144 @@
+package core
+
+// WorkQueue in-memory storage implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemoryStore implements Store interface and keeps elements in memory, it is
+// used by single node deployments and tests
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	elements map[string][]byte // {id: JSON representation of element}
+	revs     map[string]int    // {id: revision number}
+}
+
+// NewMemoryStore returns new instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{elements: make(map[string][]byte), revs: make(map[string]int)}
+}
+
+// helper function to save element under the lock, elements are stored as
+// JSON to avoid sharing their maps and slices with the callers
+func (s *MemoryStore) save(wqe *WorkQueueElement) error {
+	s.revs[wqe.ID]++
+	wqe.Rev = fmt.Sprintf("%d-memory", s.revs[wqe.ID])
+	data, err := json.Marshal(wqe)
+	if err != nil {
+		return err
+	}
+	s.elements[wqe.ID] = data
+	return nil
+}
+
+// helper function to load element under the lock
+func (s *MemoryStore) load(id string) (WorkQueueElement, error) {
+	var wqe WorkQueueElement
+	data, ok := s.elements[id]
+	if !ok {
+		return wqe, ErrNotFound
+	}
+	err := json.Unmarshal(data, &wqe)
+	return wqe, err
+}
+
+// Insert method satisfy Store interface
+func (s *MemoryStore) Insert(elements []WorkQueueElement) (InsertSummary, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var summary InsertSummary
+	for i := range elements {
+		wqe := &elements[i]
+		if wqe.ID == "" {
+			wqe.ID = ElementID(*wqe)
+		}
+		if stored, err := s.load(wqe.ID); err == nil {
+			merged, changed := mergeElement(stored, *wqe)
+			if changed {
+				if err := s.save(&merged); err != nil {
+					summary.fail(wqe.ID, err)
+					continue
+				}
+			}
+			*wqe = merged
+			summary.Merged = append(summary.Merged, wqe.ID)
+			continue
+		}
+		wqe.Rev = ""
+		if err := s.save(wqe); err != nil {
+			summary.fail(wqe.ID, err)
+			continue
+		}
+		summary.Inserted = append(summary.Inserted, wqe.ID)
+	}
+	return summary, summary.err()
+}
+
+// Get method satisfy Store interface
+func (s *MemoryStore) Get(id string) (WorkQueueElement, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.load(id)
+}
+
+// Request method satisfy Store interface
+func (s *MemoryStore) Request(rname string) []WorkQueueElement {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var out []WorkQueueElement
+	for id := range s.elements {
+		wqe, err := s.load(id)
+		if err == nil && (rname == "" || wqe.RequestName == rname) {
+			out = append(out, wqe)
+		}
+	}
+	return out
+}
+
+// Query method satisfy Store interface
+func (s *MemoryStore) Query(status, site string) []WorkQueueElement {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var out []WorkQueueElement
+	for id := range s.elements {
+		wqe, err := s.load(id)
+		if err == nil && matchQuery(wqe, status, site) {
+			out = append(out, wqe)
+		}
+	}
+	sortByPriority(out)
+	return out
+}
+
+// Update method satisfy Store interface
+func (s *MemoryStore) Update(wqe *WorkQueueElement) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.load(wqe.ID)
+	if err != nil {
+		return err
+	}
+	if stored.Rev != wqe.Rev {
+		return ErrConflict
+	}
+	return s.save(wqe)
+}
+
+// Delete method satisfy Store interface
+func (s *MemoryStore) Delete(wqe WorkQueueElement) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.load(wqe.ID)
+	if err != nil {
+		return err
+	}
+	if stored.Rev != wqe.Rev {
+		return ErrConflict
+	}
+	delete(s.elements, wqe.ID)
+	return nil
+}
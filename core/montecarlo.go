@@ -4,8 +4,22 @@ package core
 // Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
 
 import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/vkuznet/WorkQueue/utils"
-	"github.com/zemirco/couchdb"
+)
+
+func init() {
+	RegisterPolicy("MonteCarlo", func(record, config utils.Record) Policy {
+		return &MonteCarloPolicy{Name: "MonteCarlo", Record: record, Config: config}
+	})
+}
+
+// default values of MonteCarlo splitting parameters
+const (
+	defaultEventsPerJob      = 1000
+	defaultMaxJobsPerElement = 1000
 )
 
 // MonteCarloPolicy defines block policy
@@ -16,12 +30,124 @@ type MonteCarloPolicy struct {
 }
 
 // Split method satisfy Policy interface
-func (b *MonteCarloPolicy) Split() []couchdb.CouchDoc {
-	var out []couchdb.CouchDoc
-	return out
+// MonteCarlo request is split into slices of events, each slice contains
+// SliceSize events per job and up to MaxJobsPerElement jobs
+func (b *MonteCarloPolicy) Split() ([]WorkQueueElement, error) {
+	var out []WorkQueueElement
+	var sPolicy, ePolicy string
+	v1 := configValue(b.Config, "policies.start.policyName")
+	if v1 != nil {
+		sPolicy = v1.(string)
+	}
+	v2 := configValue(b.Config, "policies.end.policyName")
+	if v2 != nil {
+		ePolicy = v2.(string)
+	}
+	maxJobs := intValue(configValue(b.Config, "policies.start.MaxJobsPerElement"))
+	if maxJobs <= 0 {
+		maxJobs = defaultMaxJobsPerElement
+	}
+	for rname, spec := range b.Record { // reqMgr2 record is {request_name: request_spec}
+		if InWorkQueue(rname) {
+			break
+		}
+		switch rec := spec.(type) {
+		case map[string]interface{}:
+			requestName, _ := rec["RequestName"].(string)
+			if rname != requestName {
+				log.Warnf("ReqMgr2 rname=%s != RequestName=%s", rname, requestName)
+			}
+			dbs, _ := rec["DbsUrl"].(string)
+			wmSpec, _ := rec["RequestWorkflow"].(string)
+			priority := intValue(rec["RequestPriority"])
+			totalEvents := intValue(specValue(rec, "RequestNumEvents"))
+			eventsPerJob := intValue(configValue(b.Config, "policies.start.SliceSize"))
+			if eventsPerJob <= 0 {
+				eventsPerJob = intValue(specValue(rec, "EventsPerJob"))
+			}
+			if eventsPerJob <= 0 {
+				eventsPerJob = defaultEventsPerJob
+			}
+			eventsPerLumi := intValue(specValue(rec, "EventsPerLumi"))
+			if eventsPerLumi <= 0 {
+				eventsPerLumi = eventsPerJob
+			}
+			blowupFactor := BlowupFactor(rec)
+			pileupData := pileupLocations(rec)
+			noPileupUpdate := boolValue(rec, "TrustPUSitelists")
+			firstEvent := intValue(specValue(rec, "FirstEvent"))
+			if firstEvent <= 0 {
+				firstEvent = 1
+			}
+			firstLumi := intValue(specValue(rec, "FirstLumi"))
+			if firstLumi <= 0 {
+				firstLumi = 1
+			}
+			firstRun := intValue(specValue(rec, "FirstRun"))
+			if firstRun <= 0 {
+				firstRun = 1
+			}
+			if totalEvents <= 0 {
+				return out, errors.New("MonteCarlo policy: request does not specify RequestNumEvents")
+			}
+
+			// create one element per slice of events
+			eventsPerElement := eventsPerJob * maxJobs
+			for remaining := totalEvents; remaining > 0; {
+				nevents := eventsPerElement
+				if remaining < nevents {
+					nevents = remaining
+				}
+				nlumis := ceilDiv(nevents, eventsPerLumi)
+				mask := Mask{
+					InclusiveMask: true,
+					FirstEvent:    int64(firstEvent),
+					LastEvent:     int64(firstEvent + nevents - 1),
+					FirstLumi:     firstLumi,
+					LastLumi:      firstLumi + nlumis - 1,
+					FirstRun:      firstRun,
+					LastRun:       firstRun,
+				}
+				wqe := WorkQueueElement{
+					PileupData:     pileupData,
+					NoPileupUpdate: noPileupUpdate,
+					NumberOfLumis:  nlumis,
+					NumberOfEvents: nevents,
+					Jobs:           ceilDiv(nevents, eventsPerJob),
+					BlowupFactor:   blowupFactor,
+					WMSpec:         wmSpec,
+					Mask:           mask,
+					Dbs:            dbs,
+					TaskName:       requestName,
+					RequestName:    requestName,
+					SiteWhiteList:  stringList(rec["SiteWhitelist"]),
+					SiteBlackList:  stringList(rec["SiteBlacklist"]),
+					Priority:       priority,
+					StartPolicy:    sPolicy,
+					EndPolicy:      ePolicy,
+				}
+				wqe.checkLocation()
+				out = append(out, wqe)
+				remaining -= nevents
+				firstEvent += nevents
+				firstLumi += nlumis
+			}
+		}
+	}
+	return out, nil
 }
 
-// Split method satisfy Policy interface
-func (b *MonteCarloPolicy) Validate() bool {
-	return true
+// Validate method satisfy Policy interface
+func (b *MonteCarloPolicy) Validate() error {
+	_, rec := requestSpec(b.Record)
+	if rec == nil {
+		return errors.New("MonteCarlo policy: no request spec in ReqMgr2 record")
+	}
+	if intValue(specValue(rec, "RequestNumEvents")) <= 0 {
+		return errors.New("MonteCarlo policy: request does not specify positive RequestNumEvents")
+	}
+	if v := specValue(rec, "EventsPerJob"); v != nil && intValue(v) < 0 {
+		return errors.New("MonteCarlo policy: negative EventsPerJob")
+	}
+	return nil
 }
@@ -0,0 +1,132 @@
+package core
+
+// WorkQueue open running requests implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// ReqMgr2 states of requests which accept new data
+var openStates = []string{"assigned", "acquired", "running-open"}
+
+// AddNewData adds new blocks of input dataset to requests open for new data,
+// the record holds request name and its elements. Request is closed to new
+// data when its ReqMgr2 status is no longer open, or when no new data was
+// found during request OpenRunningTimeout, in which case ReqMgr2 status is
+// changed to running-closed.
+func AddNewData(record utils.Record) {
+	for rname, val := range record {
+		elements, ok := val.([]WorkQueueElement)
+		if !ok || !openForNewData(elements) {
+			continue
+		}
+		spec := services.RequestSpec(rname)
+		if spec == nil {
+			continue
+		}
+		status, _ := spec["RequestStatus"].(string)
+		if !utils.InList(status, openStates) {
+			closeElements(rname, elements)
+			continue
+		}
+		if n := addNewBlocks(rname, spec, elements); n > 0 {
+			log.WithFields(log.Fields{"request": rname, "elements": n}).Info("Add new data")
+			continue
+		}
+		timeout := int64(intValue(specValue(spec, "OpenRunningTimeout")))
+		if time.Now().Unix()-lastNewData(elements) < timeout {
+			continue
+		}
+		if closeElements(rname, elements) && status == "running-open" {
+			if err := services.SetRequestStatus(rname, "running-closed"); err != nil {
+				log.WithFields(log.Fields{"request": rname}).Warn("Unable to close request: ", err)
+			}
+		}
+	}
+}
+
+// helper function to check if request of given elements is open for new data
+func openForNewData(elements []WorkQueueElement) bool {
+	for _, wqe := range elements {
+		if wqe.OpenForNewData {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to return time when new data were added to the request,
+// i.e. the time when input data of its last element were found. Elements
+// stored without this time fall back to the time they became available.
+func lastNewData(elements []WorkQueueElement) int64 {
+	var last int64
+	for _, wqe := range elements {
+		ts := wqe.FoundNewData
+		if ts == 0 {
+			ts = wqe.Timestamps[Available]
+		}
+		if ts > last {
+			last = ts
+		}
+	}
+	return last
+}
+
+// helper function to split blocks of the request which are not in WorkQueue
+// yet and insert new elements, it returns number of new elements
+func addNewBlocks(rname string, spec map[string]interface{}, elements []WorkQueueElement) int {
+	config := services.RequestConfig(rname)
+	if requestType(config) != "Block" {
+		return 0
+	}
+	var queued []string
+	for _, wqe := range elements {
+		for block := range wqe.Inputs {
+			queued = append(queued, block)
+		}
+	}
+	policy := &BlockPolicy{Name: "Block", Record: utils.Record{rname: spec}, Config: config, Queued: utils.List2Set(queued)}
+	newElements, err := policy.Split()
+	if err != nil {
+		log.WithFields(log.Fields{"request": rname}).Warn("Unable to split new data: ", err)
+		return 0
+	}
+	teams := requestTeams(spec)
+	for i := range newElements {
+		if err := newElements[i].SetStatus(Available); err != nil {
+			log.WithFields(log.Fields{"request": rname}).Error(err)
+			return 0
+		}
+		newElements[i].Teams = teams
+	}
+	if len(newElements) == 0 {
+		return 0
+	}
+	summary, err := Storage.Insert(newElements)
+	if err != nil {
+		log.WithFields(log.Fields{"request": rname}).Warn("Unable to insert new data: ", err)
+	}
+	return len(summary.Inserted)
+}
+
+// helper function to close elements of the request to new data, it returns
+// true if all elements were updated
+func closeElements(rname string, elements []WorkQueueElement) bool {
+	var open []WorkQueueElement
+	for _, wqe := range elements {
+		if wqe.OpenForNewData {
+			wqe.OpenForNewData = false
+			open = append(open, wqe)
+		}
+	}
+	if updateElements(open) != len(open) {
+		return false
+	}
+	log.WithFields(log.Fields{"request": rname}).Info("Close request to new data")
+	return true
+}
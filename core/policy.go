@@ -0,0 +1,76 @@
+package core
+
+// WorkQueue policy registry
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// Policy interface defines policy methods
+type Policy interface {
+	Split() ([]WorkQueueElement, error)
+	Validate() error
+}
+
+// PolicyConstructor creates a start policy for given ReqMgr2 record and request config
+type PolicyConstructor func(record, config utils.Record) Policy
+
+// registry of start policies
+var policies = struct {
+	sync.RWMutex
+	constructors map[string]PolicyConstructor
+}{constructors: make(map[string]PolicyConstructor)}
+
+// RegisterPolicy registers start policy constructor under given name,
+// registration with existing name replaces previous policy
+func RegisterPolicy(name string, constructor PolicyConstructor) {
+	policies.Lock()
+	defer policies.Unlock()
+	policies.constructors[name] = constructor
+}
+
+// NewPolicy creates start policy registered under given name
+func NewPolicy(name string, record, config utils.Record) (Policy, error) {
+	policies.RLock()
+	constructor, ok := policies.constructors[name]
+	policies.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown start policy %s, known policies %v", name, Policies())
+	}
+	return constructor(record, config), nil
+}
+
+// Policies returns names of registered start policies
+func Policies() []string {
+	policies.RLock()
+	defer policies.RUnlock()
+	var out []string
+	for name := range policies.constructors {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// helper function to check if start policy is registered
+func knownPolicy(name string) bool {
+	policies.RLock()
+	defer policies.RUnlock()
+	_, ok := policies.constructors[name]
+	return ok
+}
+
+// helper function to return request name and request spec from ReqMgr2 record
+func requestSpec(record utils.Record) (string, map[string]interface{}) {
+	for rname, spec := range record { // reqMgr2 record is {request_name: request_spec}
+		if rec, ok := spec.(map[string]interface{}); ok {
+			return rname, rec
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,35 @@
+package core
+
+// WorkQueue element priority implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// UpdatePriority propagates priority of the request to its queued elements,
+// i.e. elements which are Available or Acquired by an agent. Updated elements
+// are written back to given list with their new revisions. It returns number
+// of updated elements.
+func UpdatePriority(rname string, priority int, elements []WorkQueueElement) int {
+	var updated int
+	for i := range elements {
+		wqe := elements[i]
+		if wqe.Priority == priority || (wqe.Status != Available && wqe.Status != Acquired) {
+			continue
+		}
+		wqe.Priority = priority
+		// elements modified concurrently are rejected with a conflict and their
+		// priority is updated in the next cycle
+		if err := Storage.Update(&wqe); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID, "request": rname}).Warn("Unable to update element: ", err)
+			continue
+		}
+		elements[i] = wqe
+		updated++
+	}
+	if updated > 0 {
+		log.WithFields(log.Fields{"request": rname, "priority": priority, "elements": updated}).Info("Update priority")
+	}
+	return updated
+}
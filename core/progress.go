@@ -0,0 +1,165 @@
+package core
+
+// WorkQueue element progress implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+)
+
+// number of attempts to update element modified concurrently
+const updateRetries = 3
+
+// ElementProgress represents progress of the element reported by the agent
+// or child queue which acquired the element
+type ElementProgress struct {
+	ID              string  `json:"id"`              // element id
+	Agent           string  `json:"agent"`           // agent (or child queue) url
+	Status          string  `json:"status"`          // new status of the element
+	PercentComplete float32 `json:"percentComplete"` // percent of completed work
+	PercentSuccess  float32 `json:"percentSuccess"`  // percent of successful work
+	JobsDone        int     `json:"jobsDone"`        // number of successful jobs
+	JobsFailed      int     `json:"jobsFailed"`      // number of failed jobs
+	FilesProcessed  int     `json:"filesProcessed"`  // number of processed files
+	EventsWritten   int     `json:"eventsWritten"`   // number of written events
+}
+
+// RequestProgress represents progress of the request aggregated over its elements
+type RequestProgress struct {
+	Elements        int            `json:"elements"`        // number of elements
+	Status          map[string]int `json:"status"`          // {status: number of elements}
+	Jobs            int            `json:"jobs"`            // number of jobs
+	JobsDone        int            `json:"jobsDone"`        // number of successful jobs
+	JobsFailed      int            `json:"jobsFailed"`      // number of failed jobs
+	FilesProcessed  int            `json:"filesProcessed"`  // number of processed files
+	EventsWritten   int            `json:"eventsWritten"`   // number of written events
+	PercentComplete float32        `json:"percentComplete"` // percent of completed work
+	PercentSuccess  float32        `json:"percentSuccess"`  // percent of successful work
+}
+
+// ProgressResponse represents response to progress reports of the agent or
+// child queue
+type ProgressResponse struct {
+	Updated  int               `json:"updated"`  // number of updated elements
+	Rejected map[string]string `json:"rejected"` // {id: reason} of rejected reports
+	NotFound []string          `json:"notFound"` // ids of reported elements which are not in WorkQueue
+	Cancel   []string          `json:"cancel"`   // ids of elements which should be canceled
+}
+
+// helper function to check consistency of progress report
+func (p ElementProgress) validate() error {
+	if p.JobsDone < 0 || p.JobsFailed < 0 || p.FilesProcessed < 0 || p.EventsWritten < 0 {
+		return fmt.Errorf("negative progress counters")
+	}
+	if p.PercentComplete < 0 || p.PercentComplete > 100 || p.PercentSuccess < 0 || p.PercentSuccess > 100 {
+		return fmt.Errorf("percentage out of range")
+	}
+	return nil
+}
+
+// helper function to apply progress report to the element, percentages are
+// derived from job counters if report does not provide them
+func (w *WorkQueueElement) applyProgress(p ElementProgress) {
+	w.JobsDone = p.JobsDone
+	w.JobsFailed = p.JobsFailed
+	w.FilesProcessed = p.FilesProcessed
+	w.EventsWritten = p.EventsWritten
+	w.PercentComplete = p.PercentComplete
+	w.PercentSuccess = p.PercentSuccess
+	njobs := p.JobsDone + p.JobsFailed
+	if p.PercentComplete == 0 && p.PercentSuccess == 0 && njobs > 0 {
+		total := w.TotalJobs()
+		if total < njobs {
+			total = njobs
+		}
+		w.PercentComplete = float32(100 * float64(njobs) / float64(total))
+		w.PercentSuccess = float32(100 * float64(p.JobsDone) / float64(njobs))
+	}
+}
+
+// UpdateProgress applies progress reports to WorkQueue elements, it returns
+// number of updated elements and errors of rejected reports, i.e. {id: error}
+func UpdateProgress(reports []ElementProgress) (int, map[string]error) {
+	var updated int
+	errs := make(map[string]error)
+	for _, report := range reports {
+		if err := updateProgress(report); err != nil {
+			errs[report.ID] = err
+			continue
+		}
+		updated++
+	}
+	return updated, errs
+}
+
+// helper function to apply single progress report, the element is re-read
+// and update is repeated if element was modified concurrently
+func updateProgress(report ElementProgress) error {
+	if err := report.validate(); err != nil {
+		return err
+	}
+	var err error
+	for i := 0; i < updateRetries; i++ {
+		var wqe WorkQueueElement
+		wqe, err = Storage.Get(report.ID)
+		if err != nil {
+			return err
+		}
+		if wqe.ChildQueueUrl != report.Agent {
+			return fmt.Errorf("element is acquired by %s and not by %s", wqe.ChildQueueUrl, report.Agent)
+		}
+		// while cancellation is requested only its acknowledgement changes status
+		if wqe.Status == CancelRequested && report.Status != Canceled {
+			report.Status = wqe.Status
+		}
+		if report.Status != "" && report.Status != wqe.Status {
+			if err := wqe.SetStatus(report.Status); err != nil {
+				return err
+			}
+		}
+		wqe.applyProgress(report)
+		if !wqe.InEndState() {
+			wqe.RenewLease()
+		}
+		if err = Storage.Update(&wqe); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// AggregateProgress aggregates progress of request elements, percentages of
+// elements are weighted by their number of jobs
+func AggregateProgress(elements []WorkQueueElement) RequestProgress {
+	p := RequestProgress{Elements: len(elements), Status: make(map[string]int)}
+	var total, complete, success float64
+	for _, wqe := range elements {
+		p.Status[wqe.Status]++
+		p.Jobs += wqe.Jobs
+		p.JobsDone += wqe.JobsDone
+		p.JobsFailed += wqe.JobsFailed
+		p.FilesProcessed += wqe.FilesProcessed
+		p.EventsWritten += wqe.EventsWritten
+		weight := float64(wqe.Jobs)
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		complete += weight * float64(wqe.PercentComplete)
+		success += weight * float64(wqe.PercentSuccess)
+	}
+	if total > 0 {
+		p.PercentComplete = float32(complete / total)
+		p.PercentSuccess = float32(success / total)
+	}
+	return p
+}
+
+// RequestsProgress returns progress of all requests in WorkQueue
+func RequestsProgress() map[string]RequestProgress {
+	out := make(map[string]RequestProgress)
+	for rname, elements := range RequestElements("") {
+		out[rname] = AggregateProgress(elements)
+	}
+	return out
+}
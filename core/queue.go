@@ -2,6 +2,8 @@ package core
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -24,12 +26,13 @@ type WorkQueueElement struct {
 	NumberOfEvents  int
 	Jobs            int
 	OpenForNewData  bool
+	FoundNewData    int64 // time when input data of the element were found
 	NoInputUpdate   bool
 	NoPileupUpdate  bool
 	WMSpec          string
-	Mask            map[string]int
-	BlowupFactor    int
-	ACDC            string
+	Mask            Mask
+	BlowupFactor    float64
+	ACDC            ACDCInfo
 	RequestName     string
 	TaskName        string
 	Dbs             string
@@ -37,13 +40,23 @@ type WorkQueueElement struct {
 	SiteBlackList   []string
 	Priority        int
 	ParentQueueUrl  string
+	ParentQueueId   string // id of the element in parent queue
 	ChildQueueUrl   string
+	Teams           []string // teams of agents assigned to the request
+	LeaseExpire     int64    // time when lease of acquiring agent expires
+	Retries         int      // number of times element was requeued after lease expiry
 	PercentSuccess  float32
 	PercentComplete float32
 	WMBSUrl         string
 	FilesProcessed  int
+	JobsDone        int // number of successful jobs reported by agent
+	JobsFailed      int // number of failed jobs reported by agent
+	EventsWritten   int // number of events written by agent jobs
 	StartPolicy     string
 	EndPolicy       string
+	NoLocation      bool             // element can't run at any site
+	Status          string           // element status
+	Timestamps      map[string]int64 // {status: time of transition}
 }
 
 // Mask data structure keeps track of run-lumi
@@ -54,14 +67,16 @@ type Mask struct {
 	FirstLumi     int
 	LastLumi      int
 	FirstRun      int
-	LstRun        int
+	LastRun       int
 	RunAndLumis   map[int][]int
 }
 
-// Policy interface defines policy methods
-type Policy interface {
-	Split() []WorkQueueElement
-	Validate() bool
+// ACDCInfo data structure keeps track of ACDC collection used by resubmission
+type ACDCInfo struct {
+	Server     string
+	Database   string
+	Collection string
+	Fileset    string
 }
 
 // String function implements Stringer interface
@@ -82,3 +97,105 @@ func configValue(config utils.Record, key string) interface{} {
 	}
 	return nil
 }
+
+// helper function to convert ReqMgr2 record or config value into integer,
+// ReqMgr2 records are decoded with json.Number while config values are int64
+func intValue(v interface{}) int {
+	switch val := v.(type) {
+	case int:
+		return val
+	case int64:
+		return int(val)
+	case float64:
+		return int(val)
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := val.Float64(); err == nil {
+			return int(f)
+		}
+	case string:
+		if i, err := strconv.Atoi(val); err == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// helper function to convert ReqMgr2 record or config value into float
+func floatValue(v interface{}) float64 {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case float64:
+		return val
+	case json.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+	case string:
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+// helper function to convert list value of ReqMgr2 record into list of strings
+func stringList(v interface{}) []string {
+	var out []string
+	switch val := v.(type) {
+	case []string:
+		return val
+	case []interface{}:
+		for _, item := range val {
+			out = append(out, fmt.Sprintf("%v", item))
+		}
+	case string:
+		if val != "" {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+// helper function to convert list value of ReqMgr2 record into list of integers
+func intList(v interface{}) []int {
+	var out []int
+	switch val := v.(type) {
+	case []int:
+		return val
+	case []interface{}:
+		for _, item := range val {
+			out = append(out, intValue(item))
+		}
+	}
+	return out
+}
+
+// helper function to find value in ReqMgr2 request spec, the value is looked
+// up in request itself and then in its first task/step (TaskChain, StepChain)
+func specValue(rec map[string]interface{}, key string) interface{} {
+	if v, ok := rec[key]; ok {
+		return v
+	}
+	for _, task := range []string{"Task1", "Step1"} {
+		if t, ok := rec[task].(map[string]interface{}); ok {
+			if v, ok := t[key]; ok {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to divide two integers and round result up
+func ceilDiv(a, b int) int {
+	if b <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
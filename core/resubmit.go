@@ -4,24 +4,144 @@ package core
 // Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/services"
 	"github.com/vkuznet/WorkQueue/utils"
-	"github.com/zemirco/couchdb"
 )
 
+// default number of ACDC files in a single chunk
+const defaultACDCChunkSize = 250
+
+// ACDCSource defines ACDC service used by ResubmitBlock policies created via
+// policy registry, if not set ACDC CouchDB from request is used
+var ACDCSource services.ACDC
+
+func init() {
+	RegisterPolicy("ResubmitBlock", func(record, config utils.Record) Policy {
+		return &ResubmitBlockPolicy{Name: "ResubmitBlock", Record: record, Config: config, ACDC: ACDCSource}
+	})
+}
+
 // ResubmitBlockPolicy defines block policy
 type ResubmitBlockPolicy struct {
 	Name   string
 	Record utils.Record
 	Config utils.Record
+	ACDC   services.ACDC // ACDC service, if not set ACDC CouchDB from request is used
 }
 
 // Split method satisfy Policy interface
-func (b *ResubmitBlockPolicy) Split() []couchdb.CouchDoc {
-	var out []couchdb.CouchDoc
-	return out
+// ResubmitBlock request is split into chunks of failed files stored in ACDC
+func (b *ResubmitBlockPolicy) Split() ([]WorkQueueElement, error) {
+	var out []WorkQueueElement
+	var sPolicy, ePolicy string
+	v1 := configValue(b.Config, "policies.start.policyName")
+	if v1 != nil {
+		sPolicy = v1.(string)
+	}
+	v2 := configValue(b.Config, "policies.end.policyName")
+	if v2 != nil {
+		ePolicy = v2.(string)
+	}
+	chunkSize := intValue(configValue(b.Config, "policies.start.SliceSize"))
+	if chunkSize <= 0 {
+		chunkSize = defaultACDCChunkSize
+	}
+	for rname, spec := range b.Record { // reqMgr2 record is {request_name: request_spec}
+		if InWorkQueue(rname) {
+			break
+		}
+		switch rec := spec.(type) {
+		case map[string]interface{}:
+			requestName, _ := rec["RequestName"].(string)
+			if rname != requestName {
+				log.Warnf("ReqMgr2 rname=%s != RequestName=%s", rname, requestName)
+			}
+			acdc := acdcInfo(rec)
+			service := b.ACDC
+			if service == nil {
+				service = &services.ACDCCouch{Url: acdc.Server, Database: acdc.Database}
+			}
+			files, err := service.Files(acdc.Collection, acdc.Fileset)
+			if err != nil {
+				return out, fmt.Errorf("ResubmitBlock policy: unable to fetch ACDC files of %s/%s, %v", acdc.Collection, acdc.Fileset, err)
+			}
+			taskName := acdc.Fileset
+			if arr := strings.Split(acdc.Fileset, "/"); len(arr) > 0 && arr[len(arr)-1] != "" {
+				taskName = arr[len(arr)-1]
+			}
+			dbs, _ := rec["DbsUrl"].(string)
+			wmSpec, _ := rec["RequestWorkflow"].(string)
+			priority := intValue(rec["RequestPriority"])
+			splitting := NewSplitting(rec, b.Config)
+			blowupFactor := BlowupFactor(rec)
+
+			// create one element per chunk of failed files
+			for _, chunk := range services.ACDCChunks(files, chunkSize) {
+				acdcBlock := fmt.Sprintf("acdc:%s:%s:%d", acdc.Collection, acdc.Fileset, chunk.Offset)
+				mask := Mask{RunAndLumis: make(map[int][]int)}
+				for _, f := range chunk.Files {
+					for _, r := range f.RunLumis {
+						for _, lumi := range r.Lumis {
+							mask.RunAndLumis[int(r.Run)] = append(mask.RunAndLumis[int(r.Run)], int(lumi))
+						}
+					}
+				}
+				wqe := WorkQueueElement{
+					Inputs:         map[string][]string{acdcBlock: chunk.Locations},
+					NumberOfLumis:  chunk.NumberOfLumis(),
+					NumberOfFiles:  chunk.NumberOfFiles(),
+					NumberOfEvents: chunk.NumberOfEvents(),
+					Jobs:           splitting.Jobs(chunk.NumberOfFiles(), chunk.NumberOfLumis(), chunk.NumberOfEvents()),
+					BlowupFactor:   blowupFactor,
+					WMSpec:         wmSpec,
+					Mask:           mask,
+					ACDC:           acdc,
+					Dbs:            dbs,
+					TaskName:       taskName,
+					RequestName:    requestName,
+					SiteWhiteList:  stringList(rec["SiteWhitelist"]),
+					SiteBlackList:  stringList(rec["SiteBlacklist"]),
+					Priority:       priority,
+					StartPolicy:    sPolicy,
+					EndPolicy:      ePolicy,
+				}
+				wqe.checkLocation()
+				out = append(out, wqe)
+			}
+		}
+	}
+	return out, nil
 }
 
-// Split method satisfy Policy interface
-func (b *ResubmitBlockPolicy) Validate() bool {
-	return true
+// Validate method satisfy Policy interface
+func (b *ResubmitBlockPolicy) Validate() error {
+	_, rec := requestSpec(b.Record)
+	if rec == nil {
+		return errors.New("ResubmitBlock policy: no request spec in ReqMgr2 record")
+	}
+	acdc := acdcInfo(rec)
+	if b.ACDC == nil && (acdc.Server == "" || acdc.Database == "") {
+		return errors.New("ResubmitBlock policy: request does not specify ACDCServer and ACDCDatabase")
+	}
+	if acdc.Collection == "" || acdc.Fileset == "" {
+		return errors.New("ResubmitBlock policy: request does not specify ACDC collection (OriginalRequestName) and fileset (InitialTaskPath)")
+	}
+	return nil
+}
+
+// helper function to extract ACDC information from ReqMgr2 request spec
+func acdcInfo(rec map[string]interface{}) ACDCInfo {
+	server, _ := rec["ACDCServer"].(string)
+	database, _ := rec["ACDCDatabase"].(string)
+	collection, _ := rec["OriginalRequestName"].(string)
+	if v, ok := rec["CollectionName"].(string); ok && v != "" {
+		collection = v
+	}
+	fileset, _ := rec["InitialTaskPath"].(string)
+	return ACDCInfo{Server: server, Database: database, Collection: collection, Fileset: fileset}
 }
@@ -0,0 +1,69 @@
+package core
+
+// WorkQueue element status implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// WorkQueue element states
+const (
+	Available       = "Available"       // element is waiting for an agent
+	Negotiating     = "Negotiating"     // element is offered to an agent
+	Acquired        = "Acquired"        // element is acquired by an agent
+	Running         = "Running"         // agent runs jobs of the element
+	Done            = "Done"            // element is successfully processed
+	Failed          = "Failed"          // element processing failed
+	CancelRequested = "CancelRequested" // element cancellation is requested
+	Canceled        = "Canceled"        // element is canceled
+)
+
+// statusTransitions defines allowed transitions of element states,
+// an empty status represents a new element
+var statusTransitions = map[string][]string{
+	"":              {Available},
+	Available:       {Negotiating, Acquired, Failed, Canceled},
+	Negotiating:     {Available, Acquired, CancelRequested, Canceled},
+	Acquired:        {Available, Running, Done, Failed, CancelRequested, Canceled},
+	Running:         {Available, Done, Failed, CancelRequested, Canceled},
+	CancelRequested: {Canceled},
+	Done:            {},
+	Failed:          {},
+	Canceled:        {},
+}
+
+// ValidTransition returns true if element can change its status from one state to another
+func ValidTransition(from, to string) bool {
+	states, ok := statusTransitions[from]
+	if !ok {
+		return false
+	}
+	return utils.InList(to, states)
+}
+
+// SetStatus changes status of the element and records time of the transition,
+// it returns an error if transition is not allowed
+func (w *WorkQueueElement) SetStatus(status string) error {
+	if _, ok := statusTransitions[status]; !ok || status == "" {
+		return fmt.Errorf("unknown element status %s", status)
+	}
+	if !ValidTransition(w.Status, status) {
+		return fmt.Errorf("element %s can't change status from %s to %s", w.ID, w.Status, status)
+	}
+	if w.Timestamps == nil {
+		w.Timestamps = make(map[string]int64)
+	}
+	w.Status = status
+	w.Timestamps[status] = time.Now().Unix()
+	return nil
+}
+
+// InEndState returns true if element reached one of its final states
+func (w WorkQueueElement) InEndState() bool {
+	states, ok := statusTransitions[w.Status]
+	return ok && w.Status != "" && len(states) == 0
+}
@@ -0,0 +1,165 @@
+package core
+
+// WorkQueue storage implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// ErrConflict is returned when stored element revision does not match
+// revision of updated element
+var ErrConflict = errors.New("element revision conflict")
+
+// ErrNotFound is returned when element is not found in the store
+var ErrNotFound = errors.New("element not found")
+
+// Store defines storage of WorkQueue elements
+type Store interface {
+	// Insert stores new elements and assigns their id and revision, element
+	// which is already stored is merged with the inserted one. It returns
+	// summary of inserted elements and an error if any of them failed.
+	Insert(elements []WorkQueueElement) (InsertSummary, error)
+	// Get returns element with given id
+	Get(id string) (WorkQueueElement, error)
+	// Request returns elements of given request, or all elements if request name is empty
+	Request(rname string) []WorkQueueElement
+	// Query returns elements with given status which can run at given site,
+	// empty status or site matches any element, the elements are ordered by priority
+	Query(status, site string) []WorkQueueElement
+	// Update stores modified element if its revision matches the stored one
+	// and assigns new revision to it, otherwise ErrConflict is returned
+	Update(wqe *WorkQueueElement) error
+	// Delete removes element with given id and revision
+	Delete(wqe WorkQueueElement) error
+}
+
+// Storage points to the store of WorkQueue elements
+var Storage Store
+
+// InsertSummary represents outcome of elements insertion
+type InsertSummary struct {
+	Inserted []string          // ids of inserted elements
+	Merged   []string          // ids of stored elements merged with inserted ones
+	Failed   map[string]string // {id: error} of elements which were not stored
+}
+
+// String returns string representation of InsertSummary
+func (s InsertSummary) String() string {
+	return fmt.Sprintf("<InsertSummary: inserted=%d merged=%d failed=%d>", len(s.Inserted), len(s.Merged), len(s.Failed))
+}
+
+// helper function to record failed element in the summary
+func (s *InsertSummary) fail(id string, err error) {
+	if s.Failed == nil {
+		s.Failed = make(map[string]string)
+	}
+	s.Failed[id] = err.Error()
+}
+
+// helper function to return an error if any of elements failed
+func (s InsertSummary) err() error {
+	if len(s.Failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unable to insert %d elements", len(s.Failed))
+}
+
+// ElementID returns deterministic id of the element derived from its
+// request, task, parent element, input data and mask, i.e. the same
+// element produced by repeated split of the request gets the same id
+func ElementID(wqe WorkQueueElement) string {
+	var inputs []string
+	for input := range wqe.Inputs {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	m := wqe.Mask
+	key := fmt.Sprintf("%s|%s|%s|%s|%d-%d|%d-%d|%d-%d", wqe.RequestName, wqe.TaskName, wqe.ParentQueueId, strings.Join(inputs, ","), m.FirstEvent, m.LastEvent, m.FirstLumi, m.LastLumi, m.FirstRun, m.LastRun)
+	hash := sha1.Sum([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// helper function to merge stored element with the same element inserted
+// again. The stored element keeps its status and progress, while request
+// attributes and data locations are taken from the inserted element. It
+// returns merged element and true if stored element was changed.
+func mergeElement(stored, wqe WorkQueueElement) (WorkQueueElement, bool) {
+	merged := stored
+	changed := false
+	if merged.Priority != wqe.Priority {
+		merged.Priority = wqe.Priority
+		changed = true
+	}
+	// request reassigned to other teams moves its elements to these teams
+	if !sameSites(merged.Teams, wqe.Teams) {
+		merged.Teams = utils.List2Set(wqe.Teams)
+		changed = true
+	}
+	// data maps are copied since stored element is used to drop its indexes
+	for _, data := range []struct {
+		stored   *map[string][]string
+		inserted map[string][]string
+	}{
+		{&merged.Inputs, wqe.Inputs},
+		{&merged.ParentData, wqe.ParentData},
+		{&merged.PileupData, wqe.PileupData},
+	} {
+		copied := false
+		for name, sites := range data.inserted {
+			old, ok := (*data.stored)[name]
+			if !ok || len(sites) == 0 || sameSites(old, sites) {
+				continue
+			}
+			if !copied {
+				*data.stored = copySites(*data.stored)
+				copied = true
+			}
+			(*data.stored)[name] = utils.List2Set(sites)
+			changed = true
+		}
+	}
+	if changed {
+		merged.checkLocation()
+	}
+	return merged, changed
+}
+
+// helper function to copy {name: sites} map of element data
+func copySites(data map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(data))
+	for name, sites := range data {
+		out[name] = sites
+	}
+	return out
+}
+
+// helper function to check if element matches given status and site
+func matchQuery(wqe WorkQueueElement, status, site string) bool {
+	if status != "" && wqe.Status != status {
+		return false
+	}
+	return site == "" || utils.InList(site, wqe.PossibleSites())
+}
+
+// helper function to update given elements, elements modified concurrently
+// are rejected with a conflict. It returns number of updated elements.
+func updateElements(elements []WorkQueueElement) int {
+	var updated int
+	for i := range elements {
+		if err := Storage.Update(&elements[i]); err != nil {
+			log.WithFields(log.Fields{"id": elements[i].ID, "request": elements[i].RequestName}).Warn("Unable to update element: ", err)
+			continue
+		}
+		updated++
+	}
+	return updated
+}
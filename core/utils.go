@@ -8,7 +8,6 @@ import (
 	"net/url"
 	"strings"
 
-	"github.com/segmentio/pointer"
 	log "github.com/sirupsen/logrus"
 	"github.com/vkuznet/WorkQueue/utils"
 
@@ -29,10 +28,11 @@ type Metrics struct {
 
 // WorkqueueStatus data type
 type WorkqueueStatus struct {
-	Addrs            []string         `json:"addrs"`     // list of all IP addresses
-	TimeStamp        int64            `json:"ts"`        // time stamp
-	Metrics          map[string]int64 `json:"metrics"`   // workqueue metrics
-	NumberOfRequests int              `json:"nRequests"` // number of requests in workqueue
+	Addrs            []string                   `json:"addrs"`     // list of all IP addresses
+	TimeStamp        int64                      `json:"ts"`        // time stamp
+	Metrics          map[string]int64           `json:"metrics"`   // workqueue metrics
+	NumberOfRequests int                        `json:"nRequests"` // number of requests in workqueue
+	Requests         map[string]RequestProgress `json:"requests"`  // progress of requests in workqueue
 }
 
 // WorkqueueMetrics defines various metrics about the agent work
@@ -102,48 +102,40 @@ func InitCouch(couchUrl, dbName string) {
 	}
 
 	DB = client.Use(dbName)
+
+	// install design documents used by WorkQueue views
+	if err = InstallDesign(DB); err != nil {
+		log.WithFields(log.Fields{
+			"couchUrl": couchUrl,
+			"dbname":   dbName,
+			"step":     "InstallDesign(DB)",
+		}).Panic(err)
+	}
+	store := NewCouchStore(DB)
+	store.Url = fmt.Sprintf("%s/%s", strings.TrimRight(couchUrl, "/"), dbName)
+	Storage = store
 }
 
 // GetWorkQueueElements returns list of request in WorkQueue
 func GetWorkQueueElements(rname string) []utils.Record {
-	params := couchdb.QueryParameters{
-		Reduce: pointer.Bool(false),
-		Stale:  pointer.String("ok"),
-	}
-	if rname != "" {
-		params = couchdb.QueryParameters{
-			Reduce: pointer.Bool(false),
-			Key:    pointer.String(fmt.Sprintf("\"%s\"", rname)),
-		}
-	}
-	design := "WorkQueue"
-	viewName := "elementsByWorkflow"
-	view := DB.View(design)
-	res, err := view.Get(viewName, params)
-	if err != nil {
-		log.WithFields(log.Fields{"view": fmt.Sprintf("%s/%s", design, viewName)}).Warn(err)
-	}
 	var out []utils.Record
-	for _, row := range res.Rows {
-		if row.Key != nil {
-			record := make(utils.Record)
-			key := row.Key.(string)
-			record[key] = row.Value
-			out = append(out, record)
-		}
+	for _, wqe := range Storage.Request(rname) {
+		out = append(out, utils.Record{wqe.RequestName: wqe})
 	}
 	return out
 }
 
 // InWorkQueue returns if given request name in WorkQueue
 func InWorkQueue(rname string) bool {
-	requests := GetWorkQueueElements(rname)
-	for _, record := range requests {
-		for name, _ := range record {
-			if name == rname {
-				return true
-			}
-		}
+	return len(Storage.Request(rname)) > 0
+}
+
+// RequestElements returns WorkQueue elements grouped by request name,
+// empty request name selects elements of all requests
+func RequestElements(rname string) map[string][]WorkQueueElement {
+	out := make(map[string][]WorkQueueElement)
+	for _, wqe := range Storage.Request(rname) {
+		out[wqe.RequestName] = append(out[wqe.RequestName], wqe)
 	}
-	return false
+	return out
 }
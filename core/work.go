@@ -0,0 +1,121 @@
+package core
+
+// WorkQueue work acquisition implementation
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"errors"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// WorkRequest represents agent request for work
+type WorkRequest struct {
+	Agent   string         `json:"agent"`   // agent (or child queue) url
+	Team    string         `json:"team"`    // team name of the agent
+	Sites   map[string]int `json:"sites"`   // {site: number of free job slots}
+	AnyTeam bool           `json:"anyTeam"` // acquire elements of any team, allowed to admins only
+}
+
+// GetWork selects Available elements which can run at agent sites with free
+// job slots, marks them as Acquired by the agent and returns them. Elements
+// assigned to a team are given to agents of this team only, unless work
+// request asks for elements of any team. Elements are taken in order of
+// their priority, and the element revision guarantees that concurrently
+// acquired element is given to a single agent only.
+func GetWork(req WorkRequest) ([]WorkQueueElement, error) {
+	var out []WorkQueueElement
+	if req.Agent == "" {
+		return out, errors.New("work request does not specify agent")
+	}
+	slots := make(map[string]int)
+	for site, n := range req.Sites {
+		if n > 0 {
+			slots[site] = n
+		}
+	}
+	if len(slots) == 0 {
+		return out, nil
+	}
+	elements := Storage.Query(Available, "")
+	for _, wqe := range elements {
+		if wqe.NoLocation || !req.AnyTeam && !matchTeam(wqe, req.Team) {
+			continue
+		}
+		site := matchSite(wqe, slots)
+		if site == "" {
+			continue
+		}
+		if err := wqe.SetStatus(Acquired); err != nil {
+			log.WithFields(log.Fields{"id": wqe.ID, "agent": req.Agent}).Warn(err)
+			continue
+		}
+		wqe.ChildQueueUrl = req.Agent
+		wqe.RenewLease()
+		if err := Storage.Update(&wqe); err != nil {
+			// element was modified by someone else, e.g. acquired by another agent
+			log.WithFields(log.Fields{"id": wqe.ID, "agent": req.Agent}).Info("Unable to acquire element: ", err)
+			continue
+		}
+		slots[site] -= wqe.TotalJobs()
+		out = append(out, wqe)
+		if len(freeSites(slots)) == 0 {
+			break
+		}
+	}
+	return out, nil
+}
+
+// helper function to sort elements by priority, elements with the same
+// priority are ordered by the time they became available
+func sortByPriority(elements []WorkQueueElement) {
+	sort.SliceStable(elements, func(i, j int) bool {
+		if elements[i].Priority != elements[j].Priority {
+			return elements[i].Priority > elements[j].Priority
+		}
+		return elements[i].Timestamps[Available] < elements[j].Timestamps[Available]
+	})
+}
+
+// helper function to check if element can be given to agent of given team,
+// element without assigned teams can be given to any agent
+func matchTeam(wqe WorkQueueElement, team string) bool {
+	return len(wqe.Teams) == 0 || utils.InList(team, wqe.Teams)
+}
+
+// helper function to return teams assigned to the request, ReqMgr2 provides
+// either Team or list of Teams
+func requestTeams(rec map[string]interface{}) []string {
+	for _, key := range []string{"Teams", "Team"} {
+		if teams := stringList(rec[key]); len(teams) > 0 {
+			return utils.List2Set(teams)
+		}
+	}
+	return nil
+}
+
+// helper function to find possible site of the element with largest number of
+// free slots, the site may be overcommitted by jobs of the element (as WMCore
+// does) so large elements are not starved by smaller ones
+func matchSite(wqe WorkQueueElement, slots map[string]int) string {
+	var site string
+	for _, s := range wqe.PossibleSites() {
+		if slots[s] > 0 && (site == "" || slots[s] > slots[site]) {
+			site = s
+		}
+	}
+	return site
+}
+
+// helper function to return sites with free slots
+func freeSites(slots map[string]int) []string {
+	var out []string
+	for site, n := range slots {
+		if n > 0 {
+			out = append(out, site)
+		}
+	}
+	return out
+}
@@ -14,11 +14,9 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/segmentio/pointer"
 	"github.com/vkuznet/WorkQueue/core"
 	"github.com/vkuznet/WorkQueue/services"
 	"github.com/vkuznet/WorkQueue/utils"
-	"github.com/zemirco/couchdb"
 )
 
 // global variable which we initialize once
@@ -91,6 +89,15 @@ func auth(r *http.Request) bool {
 	return match
 }
 
+// helper function to check if user is WorkQueue admin, without
+// authentication users are admins only if AllowAnyTeam is configured
+func admin(r *http.Request) bool {
+	if !authVar {
+		return _config.AllowAnyTeam
+	}
+	return utils.InList(utils.UserDN(r), _config.Admins)
+}
+
 // AuthHandler authenticate incoming requests and route them to appropriate handler
 func AuthHandler(w http.ResponseWriter, r *http.Request) {
 	// check if server started with hkey file (auth is required)
@@ -109,6 +116,18 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 		StatusHandler(w, r)
 	case "requests":
 		RequestHandler(w, r)
+	case "elements":
+		ElementsHandler(w, r)
+	case "getWork":
+		GetWorkHandler(w, r)
+	case "progress":
+		ProgressHandler(w, r)
+	case "cancel":
+		CancelHandler(w, r)
+	case "heartbeat":
+		HeartbeatHandler(w, r)
+	case "events":
+		EventsHandler(w, r)
 	default:
 		DefaultHandler(w, r)
 	}
@@ -125,16 +144,9 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// find our how many request in workqueue
-	params := couchdb.QueryParameters{
-		Group: pointer.Bool(true),
-	}
-	view := core.DB.View("WorkQueue")
-	res, err := view.Get("elementsByWorkflow", params)
-	if err != nil {
-		panic(err)
-	}
+	requests := core.RequestsProgress()
 	addrs := utils.HostIP()
-	astats := core.WorkqueueStatus{Addrs: addrs, TimeStamp: time.Now().Unix(), Metrics: core.WorkqueueMetrics.ToDict(), NumberOfRequests: len(res.Rows)}
+	astats := core.WorkqueueStatus{Addrs: addrs, TimeStamp: time.Now().Unix(), Metrics: core.WorkqueueMetrics.ToDict(), NumberOfRequests: len(requests), Requests: requests}
 	data, err := json.Marshal(astats)
 	if err != nil {
 		log.Println("ERROR StatusHandler", err)
@@ -166,6 +178,67 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// ElementsHandler provides WorkQueue elements grouped by request
+func ElementsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// get elements of given request, or all requests if name is not provided
+	rname := r.FormValue("request")
+	elements := core.RequestElements(rname)
+	data, err := json.Marshal(elements)
+	if err != nil {
+		log.Println("ERROR ElementsHandler", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(data)
+}
+
+// EventsHandler streams WorkQueue element events as server-sent events,
+// optional request parameter selects events of given request
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	rname := r.FormValue("request")
+	events := core.Subscribe()
+	defer core.Unsubscribe(events)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case event := <-events:
+			if rname != "" && event.Request != rname {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println("ERROR EventsHandler", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
 // DefaultHandler provides information about the agent
 func DefaultHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -186,6 +259,162 @@ type level struct {
 	Level int `json:"level"`
 }
 
+// GetWorkHandler acquires WorkQueue elements for the agent which provides
+// its team and sites with free job slots
+func GetWorkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warn("Unable to read request body: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req core.WorkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		log.Warn("Unable to parse work request: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.AnyTeam && !admin(r) {
+		http.Error(w, "Only admins are allowed to acquire work of any team", http.StatusForbidden)
+		return
+	}
+	elements, err := core.GetWork(req)
+	if err != nil {
+		log.WithFields(log.Fields{"agent": req.Agent, "team": req.Team}).Warn("Unable to get work: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(elements)
+	if err != nil {
+		log.Println("ERROR GetWorkHandler", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// ProgressHandler updates progress of WorkQueue elements reported by agents
+// or child queues which acquired them
+func ProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warn("Unable to read request body: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var reports []core.ElementProgress
+	if err := json.Unmarshal(body, &reports); err != nil {
+		log.Warn("Unable to parse progress reports: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	updated, errs := core.UpdateProgress(reports)
+	rec := core.ProgressResponse{Updated: updated, Rejected: make(map[string]string)}
+	for id, err := range errs {
+		if err == core.ErrNotFound {
+			rec.NotFound = append(rec.NotFound, id)
+			continue
+		}
+		rec.Rejected[id] = err.Error()
+	}
+	var agents []string
+	for _, report := range reports {
+		agents = append(agents, report.Agent)
+	}
+	for _, agent := range utils.List2Set(agents) {
+		rec.Cancel = append(rec.Cancel, core.PendingCancellations(agent)...)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("ERROR ProgressHandler", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// CancelHandler cancels all elements of the request
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warn("Unable to read request body: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var v struct {
+		Request string `json:"request"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		log.Warn("Unable to parse cancel request: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ncanceled, err := core.CancelRequest(v.Request)
+	if err != nil {
+		log.WithFields(log.Fields{"request": v.Request}).Warn("Unable to cancel request: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{"request": v.Request, "elements": ncanceled}).Info("Cancel request")
+	data, err := json.Marshal(map[string]int{"canceled": ncanceled})
+	if err != nil {
+		log.Println("ERROR CancelHandler", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// HeartbeatHandler renews leases of WorkQueue elements acquired by the agent
+func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warn("Unable to read request body: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var v struct {
+		Agent string `json:"agent"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		log.Warn("Unable to parse heartbeat: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	renewed, err := core.Heartbeat(v.Agent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(map[string]int{"renewed": renewed})
+	if err != nil {
+		log.Println("ERROR HeartbeatHandler", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
 // LogHandler sets verbosity level for the server
 func LogHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
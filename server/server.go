@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -18,21 +19,36 @@ import (
 
 // Config type holds server configuration
 type Config struct {
-	Workers         int    `json:"Workers"`         // number of workers in WorkQueue
-	QueueSize       int    `json:"QueueSize"`       // size of WorkQueue
-	MetricsFile     string `json:"MetricsFile"`     // file for metrics output
-	MetricsInterval int64  `json:"MetricsInterval"` // interval (in sec) to collect metrics
-	RequestType     string `json:"RequestType"`     // ReqMgr2 type of request to fetch
-	FetchInterval   int64  `json:"FetchInterval"`   // interval (in sec) to fetch ReqMgr2 data
-	CleanupInterval int64  `json:"CleanupInterval"` // interval (in sec) to cleanup WorkQueue DB
-	CouchUrl        string `json:"CouchURL"`        // couch db url
-	DBName          string `json:"DBName"`          // database name to use
-	Port            int    `json:"port"`            // port number given server runs on, default 8989
-	Base            string `json:"base"`            // URL base path for agent server, it will be extracted from Url
-	ServerKey       string `json:"serverkey"`       // server key file
-	ServerCrt       string `json:"servercrt"`       // server crt file
-	LogFormatter    string `json:"LogFormatter"`    // LogFormatter, e.g. json
-	LogLevel        string `json:"LogLevel"`        // Log level, e.g. info, warn, err
+	Workers          int            `json:"Workers"`          // number of workers in WorkQueue
+	QueueSize        int            `json:"QueueSize"`        // size of WorkQueue
+	MetricsFile      string         `json:"MetricsFile"`      // file for metrics output
+	MetricsInterval  int64          `json:"MetricsInterval"`  // interval (in sec) to collect metrics
+	RequestType      string         `json:"RequestType"`      // ReqMgr2 type of request to fetch
+	FetchInterval    int64          `json:"FetchInterval"`    // interval (in sec) to fetch ReqMgr2 data
+	CleanupInterval  int64          `json:"CleanupInterval"`  // interval (in sec) to cleanup WorkQueue DB
+	LocationInterval int64          `json:"LocationInterval"` // interval (in sec) to refresh data location of elements
+	Backend          string         `json:"Backend"`          // storage backend: couchdb (default), bolt or memory
+	BoltFile         string         `json:"BoltFile"`         // database file of bolt backend, default workqueue.db
+	CouchUrl         string         `json:"CouchURL"`         // couch db url
+	DBName           string         `json:"DBName"`           // database name to use
+	WatchChanges     bool           `json:"WatchChanges"`     // follow changes feed of CouchDB database
+	ChangesFile      string         `json:"ChangesFile"`      // checkpoint file of changes feed, default changes.checkpoint
+	Port             int            `json:"port"`             // port number given server runs on, default 8989
+	Base             string         `json:"base"`             // URL base path for agent server, it will be extracted from Url
+	ServerKey        string         `json:"serverkey"`        // server key file
+	ServerCrt        string         `json:"servercrt"`        // server crt file
+	LogFormatter     string         `json:"LogFormatter"`     // LogFormatter, e.g. json
+	LogLevel         string         `json:"LogLevel"`         // Log level, e.g. info, warn, err
+	QueueMode        string         `json:"QueueMode"`        // queue mode: global (default) or local
+	ParentQueueUrl   string         `json:"ParentQueueUrl"`   // url of parent (global) WorkQueue, used in local mode
+	QueueUrl         string         `json:"QueueUrl"`         // url of this WorkQueue known to the parent queue
+	Team             string         `json:"Team"`             // team name of agents served by local queue
+	Sites            map[string]int `json:"Sites"`            // {site: number of job slots} served by local queue
+	PullInterval     int64          `json:"PullInterval"`     // interval (in sec) to pull work from parent queue
+	Admins           []string       `json:"Admins"`           // DNs of admins allowed to acquire work of any team
+	AllowAnyTeam     bool           `json:"AllowAnyTeam"`     // allow work of any team without authentication, e.g. for testing
+	LeaseDuration    int64          `json:"LeaseDuration"`    // time (in sec) agent holds acquired element without heartbeat
+	MaxRetries       int            `json:"MaxRetries"`       // number of times element with expired lease is requeued
 }
 
 // String returns string representation of Config data type
@@ -50,7 +66,30 @@ func Server(config Config) {
 	if _config.DBName != "" {
 		dbName = _config.DBName
 	}
-	core.InitCouch(_config.CouchUrl, dbName)
+	switch _config.Backend {
+	case "memory":
+		core.Storage = core.NewMemoryStore()
+	case "bolt":
+		fname := "workqueue.db"
+		if _config.BoltFile != "" {
+			fname = _config.BoltFile
+		}
+		store, err := core.NewBoltStore(fname)
+		if err != nil {
+			log.Fatal("Unable to open bolt database: ", err)
+		}
+		core.Storage = store
+	case "", "couchdb":
+		core.InitCouch(_config.CouchUrl, dbName)
+	default:
+		log.Fatal("Unsupported storage backend: ", _config.Backend)
+	}
+	if _config.LeaseDuration > 0 {
+		core.LeaseDuration = _config.LeaseDuration
+	}
+	if _config.MaxRetries > 0 {
+		core.MaxRetries = _config.MaxRetries
+	}
 
 	port := "8989" // default port, the port here is a string type since we'll use it later in http.ListenAndServe
 	if config.Port != 0 {
@@ -63,7 +102,33 @@ func Server(config Config) {
 
 	// initialize task dispatcher
 	dispatcher := core.NewDispatcher(config.Workers, config.QueueSize, config.MetricsFile, config.MetricsInterval)
-	dispatcher.Run(config.RequestType, config.FetchInterval, config.CleanupInterval)
+	watch := config.WatchChanges && (config.Backend == "" || config.Backend == "couchdb")
+	if watch {
+		checkpoint := "changes.checkpoint"
+		if config.ChangesFile != "" {
+			checkpoint = config.ChangesFile
+		}
+		dbUrl := fmt.Sprintf("%s/%s", strings.TrimRight(config.CouchUrl, "/"), dbName)
+		watcher := core.NewChangesWatcher(dbUrl, checkpoint, 60)
+		watcher.Seed(core.Storage.Request(""))
+		watcher.Run()
+	}
+	if config.QueueMode == "local" {
+		// local queue pulls work from parent queue instead of ReqMgr2
+		lq := core.LocalQueue{
+			ParentUrl: config.ParentQueueUrl,
+			Url:       config.QueueUrl,
+			Team:      config.Team,
+			Sites:     config.Sites,
+			Interval:  config.PullInterval,
+		}
+		dispatcher.RunLocal(lq)
+	} else {
+		dispatcher.Run(config.RequestType, config.FetchInterval, config.CleanupInterval, config.LocationInterval)
+		if watch {
+			dispatcher.Watch()
+		}
+	}
 
 	var err error
 	if authVar {
@@ -0,0 +1,212 @@
+package services
+
+// ACDC module
+// Copyright (c) 2017 - Valentin Kuznetsov <vkuznet@gmail.com>
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// ACDC interface defines access to ACDC (Automatic Data Collection Database)
+// records of failed files
+type ACDC interface {
+	Files(collection, fileset string) ([]ACDCFile, error)
+}
+
+// ACDCFile represents failed file stored in ACDC
+type ACDCFile struct {
+	Lfn       string
+	Events    int
+	Locations []string
+	RunLumis  []RunLumis
+}
+
+// NumberOfLumis returns number of lumis in ACDCFile
+func (r ACDCFile) NumberOfLumis() int {
+	tot := 0
+	for _, runLumis := range r.RunLumis {
+		tot += runLumis.NumberOfLumis()
+	}
+	return tot
+}
+
+// ACDCChunk represents chunk of ACDC files which will be resubmitted together
+type ACDCChunk struct {
+	Offset    int
+	Files     []ACDCFile
+	Locations []string
+}
+
+// String implements Stringer interface
+func (r ACDCChunk) String() string {
+	return fmt.Sprintf("{Offset: %d, Files: %d, Locations: %v}", r.Offset, len(r.Files), r.Locations)
+}
+
+// NumberOfFiles returns number of files in ACDCChunk
+func (r ACDCChunk) NumberOfFiles() int {
+	return len(r.Files)
+}
+
+// NumberOfLumis returns number of lumis in ACDCChunk
+func (r ACDCChunk) NumberOfLumis() int {
+	tot := 0
+	for _, f := range r.Files {
+		tot += f.NumberOfLumis()
+	}
+	return tot
+}
+
+// NumberOfEvents returns number of events in ACDCChunk
+func (r ACDCChunk) NumberOfEvents() int {
+	tot := 0
+	for _, f := range r.Files {
+		tot += f.Events
+	}
+	return tot
+}
+
+// ACDCChunks splits ACDC files into chunks of given size. Files are grouped
+// by their locations such that all files of a chunk are available at chunk
+// locations, and ordered by their LFN to make chunks reproducible
+func ACDCChunks(files []ACDCFile, chunkSize int) []ACDCChunk {
+	var out []ACDCChunk
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+	groups := make(map[string][]ACDCFile)
+	sites := make(map[string][]string)
+	var keys []string
+	for _, f := range files {
+		locations := utils.List2Set(f.Locations)
+		sort.Strings(locations)
+		key := strings.Join(locations, ",")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			sites[key] = locations
+		}
+		groups[key] = append(groups[key], f)
+	}
+	sort.Strings(keys)
+	offset := 0
+	for _, key := range keys {
+		group := groups[key]
+		sort.Slice(group, func(i, j int) bool { return group[i].Lfn < group[j].Lfn })
+		for start := 0; start < len(group); start += chunkSize {
+			end := start + chunkSize
+			if end > len(group) {
+				end = len(group)
+			}
+			chunk := ACDCChunk{Offset: offset, Files: group[start:end], Locations: sites[key]}
+			out = append(out, chunk)
+			offset += end - start
+		}
+	}
+	return out
+}
+
+// ACDCCouch provides access to ACDC records stored in CouchDB
+type ACDCCouch struct {
+	Url      string // ACDC CouchDB server url
+	Database string // ACDC database name
+}
+
+// Files returns list of failed files for given collection and fileset
+func (a *ACDCCouch) Files(collection, fileset string) ([]ACDCFile, error) {
+	key, err := json.Marshal([]string{collection, fileset})
+	if err != nil {
+		return nil, err
+	}
+	rurl := fmt.Sprintf("%s/%s/_design/ACDC/_view/coll_fileset_docs?include_docs=true&reduce=false&key=%s", a.Url, a.Database, url.QueryEscape(string(key)))
+	resp := utils.FetchResponse(rurl, "")
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	var rec struct {
+		Rows []struct {
+			Doc acdcDocument `json:"doc"`
+		} `json:"rows"`
+	}
+	dec := json.NewDecoder(bytes.NewBuffer(resp.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&rec); err != nil {
+		msg := fmt.Sprintf("ACDC unable to unmarshal the data, data=%s, error=%v", string(resp.Data), err)
+		log.Error(msg)
+		return nil, err
+	}
+	var docs []acdcDocument
+	for _, row := range rec.Rows {
+		docs = append(docs, row.Doc)
+	}
+	return acdcFiles(docs, collection, fileset), nil
+}
+
+// LocalACDC provides access to ACDC records stored in local JSON file,
+// the file contains list of ACDC CouchDB documents
+type LocalACDC struct {
+	File string // name of JSON file
+}
+
+// Files returns list of failed files for given collection and fileset
+func (a *LocalACDC) Files(collection, fileset string) ([]ACDCFile, error) {
+	data, err := ioutil.ReadFile(a.File)
+	if err != nil {
+		return nil, err
+	}
+	var docs []acdcDocument
+	dec := json.NewDecoder(bytes.NewBuffer(data))
+	dec.UseNumber()
+	if err := dec.Decode(&docs); err != nil {
+		return nil, err
+	}
+	return acdcFiles(docs, collection, fileset), nil
+}
+
+// acdcDocument represents ACDC CouchDB document
+type acdcDocument struct {
+	Collection string                 `json:"collection_name"`
+	Fileset    string                 `json:"fileset_name"`
+	Files      map[string]acdcFileDoc `json:"files"`
+}
+
+// acdcFileDoc represents file record of ACDC CouchDB document
+type acdcFileDoc struct {
+	Lfn       string      `json:"lfn"`
+	Events    json.Number `json:"events"`
+	Locations []string    `json:"locations"`
+	Runs      []struct {
+		Run   int64   `json:"run_number"`
+		Lumis []int64 `json:"lumis"`
+	} `json:"runs"`
+}
+
+// helper function to collect files from ACDC documents of given collection and fileset
+func acdcFiles(docs []acdcDocument, collection, fileset string) []ACDCFile {
+	var out []ACDCFile
+	for _, doc := range docs {
+		if doc.Collection != collection || doc.Fileset != fileset {
+			continue
+		}
+		for lfn, f := range doc.Files {
+			if f.Lfn != "" {
+				lfn = f.Lfn
+			}
+			events, _ := f.Events.Int64()
+			var runLumis []RunLumis
+			for _, r := range f.Runs {
+				runLumis = append(runLumis, RunLumis{Run: r.Run, Lumis: r.Lumis})
+			}
+			acdcFile := ACDCFile{Lfn: lfn, Events: int(events), Locations: f.Locations, RunLumis: runLumis}
+			out = append(out, acdcFile)
+		}
+	}
+	return out
+}
@@ -76,9 +76,13 @@ func (r RunLumis) NumberOfLumis() int {
 	return len(r.Lumis)
 }
 
-// NumberOfEvents returns number of lumis in RunLumis
+// NumberOfEvents returns number of events in RunLumis
 func (r RunLumis) NumberOfEvents() int {
-	return len(r.Events)
+	tot := 0
+	for _, evts := range r.Events {
+		tot += int(evts)
+	}
+	return tot
 }
 
 // FileLumis keep track of block content
@@ -97,7 +101,7 @@ func (r FileLumis) NumberOfLumis() int {
 	return r.RunLumis.NumberOfLumis()
 }
 
-// NumberOfEvents returns number of lumis in FileEvents
+// NumberOfEvents returns number of events in FileLumis
 func (r FileLumis) NumberOfEvents() int {
 	return r.RunLumis.NumberOfEvents()
 }
@@ -127,7 +131,7 @@ func (r MaskedBlock) NumberOfLumis() int {
 	return tot
 }
 
-// NumberOfEvents returns number of lumis in MaskedBlock
+// NumberOfEvents returns number of events in MaskedBlock
 func (r MaskedBlock) NumberOfEvents() int {
 	tot := 0
 	for _, fileLumis := range r.FilesLumis {
@@ -174,6 +178,7 @@ func MaskedBlocks(blocks []string) []MaskedBlock {
 			switch r := row.(type) {
 			case []utils.Record:
 				var filesLumis []FileLumis
+				missingEvents := false
 				for _, vvv := range r {
 					lfn := vvv["logical_file_name"].(string)
 					run, _ := vvv["run_num"].(json.Number).Int64()
@@ -188,18 +193,21 @@ func MaskedBlocks(blocks []string) []MaskedBlock {
 							event, _ := v.(json.Number).Int64()
 							events = append(events, event)
 						}
-					} else { // back-up solution
-						if blockInfo == nil { // first fatch all info about blocks
-							blockInfo = blockEvents(blocks)
-						}
-						if evts, ok := blockInfo[block]; ok {
-							events = append(events, evts)
-						}
+					} else {
+						missingEvents = true
 					}
 					runLumis := RunLumis{Run: run, Lumis: lumis, Events: events}
 					fileLumis := FileLumis{Lfn: lfn, RunLumis: runLumis}
 					filesLumis = append(filesLumis, fileLumis)
 				}
+				if missingEvents { // back-up solution
+					if blockInfo == nil { // first fatch all info about blocks
+						blockInfo = blockEvents(blocks)
+					}
+					if evts, ok := blockInfo[block]; ok {
+						spreadEvents(filesLumis, evts)
+					}
+				}
 				maskedBlock := MaskedBlock{Block: block, FilesLumis: filesLumis}
 				out = append(out, maskedBlock)
 			}
@@ -208,8 +216,51 @@ func MaskedBlocks(blocks []string) []MaskedBlock {
 	return out
 }
 
+// helper function to spread number of block events not covered by DBS event
+// counts over lumis of files without event counts, such that the events of
+// the block are counted only once
+func spreadEvents(filesLumis []FileLumis, blockEvents int64) {
+	var nlumis int64
+	remaining := blockEvents
+	for _, fl := range filesLumis {
+		if len(fl.RunLumis.Events) == 0 {
+			nlumis += int64(len(fl.RunLumis.Lumis))
+		} else {
+			remaining -= int64(fl.RunLumis.NumberOfEvents())
+		}
+	}
+	if nlumis == 0 || remaining <= 0 {
+		return
+	}
+	var idx int64
+	for i, fl := range filesLumis {
+		if len(fl.RunLumis.Events) != 0 {
+			continue
+		}
+		var events []int64
+		for range fl.RunLumis.Lumis {
+			evts := remaining / nlumis
+			if idx < remaining%nlumis { // remainder goes to first lumis
+				evts++
+			}
+			events = append(events, evts)
+			idx++
+		}
+		filesLumis[i].RunLumis.Events = events
+	}
+}
+
 // ParentBlocks function retrieves block parents for given list of blocks
 func ParentBlocks(blocks []string) []string {
+	var out []string
+	for _, parents := range BlockParents(blocks) {
+		out = append(out, parents...)
+	}
+	return out
+}
+
+// BlockParents function retrieves parents of every block from given list of blocks
+func BlockParents(blocks []string) map[string][]string {
 	var requests []Request
 	// TODO: DBS provides blockparents GET API and blockparents POST API
 	// it is unclear which API to use here
@@ -218,15 +269,15 @@ func ParentBlocks(blocks []string) []string {
 		req := Request{Name: block, Url: rurl, Args: ""}
 		requests = append(requests, req)
 	}
-	var out []string
+	out := make(map[string][]string)
 	for _, rec := range Process(requests) { // key here is index, rec = {ReqName: []Records}
-		for _, row := range rec { // key here is block request.Name
+		for block, row := range rec { // key here is block request.Name
 			switch r := row.(type) {
 			case []utils.Record:
 				for _, vvv := range r {
 					switch val := vvv["parent_block_name"].(type) {
 					case string:
-						out = append(out, val)
+						out[block] = append(out[block], val)
 					}
 				}
 			}
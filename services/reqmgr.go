@@ -33,7 +33,8 @@ func loadReqMgrData(data []byte) []utils.Record {
 		msg := fmt.Sprintf("ReqMgr unable to unmarshal data, data=%s, error=%v", string(data), err)
 		log.Error(msg)
 	}
-	for _, r := range rec["result"].([]interface{}) {
+	results, _ := rec["result"].([]interface{})
+	for _, r := range results {
 		out = append(out, utils.Convert2Record(r))
 	}
 	return out
@@ -60,6 +61,42 @@ func GetRequest(name string) []utils.Record {
 	return data
 }
 
+// SetRequestStatus function updates status of given request in ReqMgr2
+func SetRequestStatus(name, status string) error {
+	rurl := fmt.Sprintf("%s/data/request/%s", reqmgrUrl(), name)
+	args, err := json.Marshal(map[string]string{"RequestStatus": status})
+	if err != nil {
+		return err
+	}
+	resp := utils.PutResponse(rurl, string(args))
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("ReqMgr2 unable to set %s status of %s: %s", status, name, resp.Status)
+	}
+	return nil
+}
+
+// RequestSpec function returns ReqMgr2 specification of given request
+func RequestSpec(name string) map[string]interface{} {
+	for _, req := range GetRequest(name) { // reqMgr2 returns always a list
+		for _, spec := range req { // reqMgr2 record is {request_name: request_spec}
+			switch rec := spec.(type) {
+			case map[string]interface{}:
+				return rec
+			}
+		}
+	}
+	return nil
+}
+
+// RequestStatus function returns ReqMgr2 status of given request
+func RequestStatus(name string) string {
+	status, _ := RequestSpec(name)["RequestStatus"].(string)
+	return status
+}
+
 // RequestConfig fetch reqmgr record configuration
 func RequestConfig(name string) utils.Record {
 	rurl := fmt.Sprintf("%s/config?name=%s", reqmgrUrl(), name)
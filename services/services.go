@@ -10,17 +10,26 @@ import (
 	"github.com/vkuznet/WorkQueue/utils"
 )
 
+// DBSUrl, PhedexUrl, SiteDBUrl and ReqMgrUrl define urls of CMS data
+// services, they can be changed to use other service instances
+var (
+	DBSUrl    = "https://cmsweb.cern.ch/dbs/prod/global/DBSReader"
+	PhedexUrl = "https://cmsweb.cern.ch/phedex/datasvc/json/prod"
+	SiteDBUrl = "https://cmsweb.cern.ch/sitedb/data/prod"
+	ReqMgrUrl = "https://cmsweb.cern.ch/reqmgr2"
+)
+
 func dbsUrl() string {
-	return "https://cmsweb.cern.ch/dbs/prod/global/DBSReader"
+	return DBSUrl
 }
 func phedexUrl() string {
-	return "https://cmsweb.cern.ch/phedex/datasvc/json/prod"
+	return PhedexUrl
 }
 func sitedbUrl() string {
-	return "https://cmsweb.cern.ch/sitedb/data/prod"
+	return SiteDBUrl
 }
 func reqmgrUrl() string {
-	return "https://cmsweb.cern.ch/reqmgr2"
+	return ReqMgrUrl
 }
 
 // Unmarshal
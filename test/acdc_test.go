@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// TestACDCChunks tests services.ACDCChunks behavior with local ACDC fixture
+func TestACDCChunks(t *testing.T) {
+	cdir, _ := os.Getwd()
+	acdc := &services.LocalACDC{File: fmt.Sprintf("%s/data/acdc.json", cdir)}
+	files, err := acdc.Files("vlimant_ACDC_Run2016B", "/vlimant_ACDC_Run2016B/DataProcessing")
+	if err != nil {
+		t.Fatalf("unable to read ACDC fixture: %v", err)
+	}
+	expect := 3
+	if len(files) != expect {
+		t.Errorf("wrong number of ACDC files: nfiles=%d != expect=%d", len(files), expect)
+	}
+	// files are grouped by their locations, each group fits into single chunk
+	chunks := services.ACDCChunks(files, 2)
+	if len(chunks) != 3 {
+		t.Fatalf("wrong number of ACDC chunks: nchunks=%d != expect=%d", len(chunks), 3)
+	}
+	tests := []struct {
+		offset, events, lumis int
+		locations             []string
+	}{
+		{0, 100, 3, []string{"T1_US_FNAL_Disk"}},
+		{1, 200, 2, []string{"T1_US_FNAL_Disk", "T2_CH_CERN"}},
+		{2, 50, 1, []string{"T2_CH_CERN"}},
+	}
+	for i, test := range tests {
+		chunk := chunks[i]
+		if chunk.Offset != test.offset || chunk.NumberOfFiles() != 1 || chunk.NumberOfEvents() != test.events || chunk.NumberOfLumis() != test.lumis {
+			t.Errorf("wrong content of chunk %d %v", i, chunk)
+		}
+		if fmt.Sprintf("%v", chunk.Locations) != fmt.Sprintf("%v", test.locations) {
+			t.Errorf("wrong locations of chunk %d %v != %v", i, chunk.Locations, test.locations)
+		}
+	}
+}
+
+// TestACDCChunksLocations tests that files of ACDC chunk share chunk locations
+func TestACDCChunksLocations(t *testing.T) {
+	files := []services.ACDCFile{
+		{Lfn: "/store/d.root", Events: 10, Locations: []string{"T2_CH_CERN"}},
+		{Lfn: "/store/c.root", Events: 10, Locations: []string{"T2_CH_CERN", "T1_US_FNAL_Disk"}},
+		{Lfn: "/store/b.root", Events: 10, Locations: []string{"T1_US_FNAL_Disk", "T2_CH_CERN"}},
+		{Lfn: "/store/a.root", Events: 10, Locations: []string{"T1_US_FNAL_Disk", "T2_CH_CERN"}},
+	}
+	chunks := services.ACDCChunks(files, 2)
+	if len(chunks) != 3 {
+		t.Fatalf("wrong number of ACDC chunks: nchunks=%d != expect=%d", len(chunks), 3)
+	}
+	first := chunks[0]
+	if first.Offset != 0 || first.NumberOfFiles() != 2 || first.Files[0].Lfn != "/store/a.root" || len(first.Locations) != 2 {
+		t.Errorf("wrong content of first chunk %v", first)
+	}
+	second := chunks[1]
+	if second.Offset != 2 || second.NumberOfFiles() != 1 || second.Files[0].Lfn != "/store/c.root" || len(second.Locations) != 2 {
+		t.Errorf("wrong content of second chunk %v", second)
+	}
+	third := chunks[2]
+	if third.Offset != 3 || third.NumberOfFiles() != 1 || third.Files[0].Lfn != "/store/d.root" || len(third.Locations) != 1 {
+		t.Errorf("wrong content of third chunk %v", third)
+	}
+	for _, chunk := range services.ACDCChunks(files, 3) {
+		for _, f := range chunk.Files {
+			for _, site := range chunk.Locations {
+				if !utils.InList(site, f.Locations) {
+					t.Errorf("file %s of chunk %v is not available at %s", f.Lfn, chunk, site)
+				}
+			}
+		}
+	}
+}
+
+// TestResubmitBlockSplit tests split of ResubmitBlock request with ACDC
+// source set via core.ACDCSource
+func TestResubmitBlockSplit(t *testing.T) {
+	cdir, _ := os.Getwd()
+	core.Storage = core.NewMemoryStore()
+	core.ACDCSource = &services.LocalACDC{File: fmt.Sprintf("%s/data/acdc.json", cdir)}
+	defer func() { core.ACDCSource = nil }()
+	spec := map[string]interface{}{
+		"RequestName":         "acdc_request",
+		"OriginalRequestName": "vlimant_ACDC_Run2016B",
+		"InitialTaskPath":     "/vlimant_ACDC_Run2016B/DataProcessing",
+	}
+	policy, err := core.NewPolicy("ResubmitBlock", utils.Record{"acdc_request": spec}, nil)
+	if err != nil {
+		t.Fatalf("unable to create ResubmitBlock policy: %v", err)
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("ResubmitBlock policy should accept request with ACDC source, %v", err)
+	}
+	elements, err := policy.Split()
+	if err != nil {
+		t.Fatalf("unable to split ResubmitBlock request: %v", err)
+	}
+	if len(elements) != 3 {
+		t.Fatalf("wrong number of elements: nelements=%d != expect=%d", len(elements), 3)
+	}
+	nfiles, nevents := 0, 0
+	for _, wqe := range elements {
+		nfiles += wqe.NumberOfFiles
+		nevents += wqe.NumberOfEvents
+		if wqe.TaskName != "DataProcessing" || wqe.NoLocation {
+			t.Errorf("wrong content of element %+v", wqe)
+		}
+	}
+	if nfiles != 3 || nevents != 350 {
+		t.Errorf("wrong number of files or events in elements, nfiles=%d nevents=%d", nfiles, nevents)
+	}
+}
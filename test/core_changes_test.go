@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/zemirco/couchdb"
+)
+
+// TestChangesWatcher tests core.ChangesWatcher behavior
+func TestChangesWatcher(t *testing.T) {
+	var since []string
+	feed := []string{
+		`{"results": [
+			{"seq": "1-a", "id": "_design/WorkQueue", "doc": {"_id": "_design/WorkQueue", "_rev": "1-x"}},
+			{"seq": "2-a", "id": "e1", "doc": {"_id": "e1", "_rev": "1-x", "RequestName": "req", "Status": "Available"}},
+			{"seq": "3-a", "id": "e1", "doc": {"_id": "e1", "_rev": "2-x", "RequestName": "req", "Status": "Acquired"}},
+			{"seq": "4-a", "id": "e1", "doc": {"_id": "e1", "_rev": "3-x", "RequestName": "req", "Status": "Acquired", "PercentComplete": 50}}
+		], "last_seq": "4-a"}`,
+		`{"results": [{"seq": "5-a", "id": "e1", "deleted": true, "doc": {"_id": "e1", "_rev": "4-x", "_deleted": true}}], "last_seq": "5-a"}`,
+	}
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since = append(since, r.FormValue("since"))
+		fmt.Fprint(w, feed[len(since)-1])
+	}))
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "workqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "changes.checkpoint")
+
+	events := core.Subscribe()
+	defer core.Unsubscribe(events)
+	watcher := core.NewChangesWatcher(db.URL, checkpoint, 1)
+	if err := watcher.Poll(); err != nil {
+		t.Fatalf("unable to poll changes: %v", err)
+	}
+	expect := []core.Event{
+		{Type: core.ElementCreated, ID: "e1", Request: "req", Status: core.Available, Seq: "2-a"},
+		{Type: core.ElementStatusChanged, ID: "e1", Request: "req", Status: core.Acquired, PreviousStatus: core.Available, Seq: "3-a"},
+	}
+	for _, e := range expect {
+		if event := <-events; event != e {
+			t.Errorf("wrong event %+v, expect %+v", event, e)
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("update without status change should not produce event %+v", <-events)
+	}
+
+	// watcher resumes from persisted checkpoint
+	watcher = core.NewChangesWatcher(db.URL, checkpoint, 1)
+	if err := watcher.Poll(); err != nil {
+		t.Fatalf("unable to poll changes: %v", err)
+	}
+	if since[0] != "now" || since[1] != "4-a" {
+		t.Errorf("wrong sequences of changes requests %v", since)
+	}
+	if event := <-events; event.Type != core.ElementDeleted || event.ID != "e1" {
+		t.Errorf("wrong event %+v", event)
+	}
+	if data, _ := ioutil.ReadFile(checkpoint); string(data) != "5-a" {
+		t.Errorf("wrong checkpoint %s", string(data))
+	}
+}
+
+// TestChangesWatcherState tests events of elements with known and unknown
+// previous status
+func TestChangesWatcherState(t *testing.T) {
+	feed := `{"results": [
+		{"seq": "1-a", "id": "e1", "doc": {"_id": "e1", "_rev": "2-x", "RequestName": "req", "Status": "Acquired"}},
+		{"seq": "2-a", "id": "e2", "doc": {"_id": "e2", "_rev": "2-x", "RequestName": "req", "Status": "Acquired"}},
+		{"seq": "3-a", "id": "e1", "doc": {"_id": "e1", "_rev": "3-x", "RequestName": "req", "Status": "Done"}}
+	], "last_seq": "3-a"}`
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, feed)
+	}))
+	defer db.Close()
+
+	events := core.Subscribe()
+	defer core.Unsubscribe(events)
+	watcher := core.NewChangesWatcher(db.URL, "", 1)
+	watcher.Seed([]core.WorkQueueElement{{Document: couchdb.Document{ID: "e2"}, RequestName: "req", Status: core.Available}})
+	if err := watcher.Poll(); err != nil {
+		t.Fatalf("unable to poll changes: %v", err)
+	}
+	// status change of e1 is not known, while its next change is
+	expect := []core.Event{
+		{Type: core.ElementStatusChanged, ID: "e2", Request: "req", Status: core.Acquired, PreviousStatus: core.Available, Seq: "2-a"},
+		{Type: core.ElementStatusChanged, ID: "e1", Request: "req", Status: core.Done, PreviousStatus: core.Acquired, Seq: "3-a"},
+	}
+	for _, e := range expect {
+		if event := <-events; event != e {
+			t.Errorf("wrong event %+v, expect %+v", event, e)
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("unexpected event %+v", <-events)
+	}
+}
+
+// TestChangesWatcherDelivery tests that events are not dropped for busy
+// subscriber and checkpoint is saved once events are delivered
+func TestChangesWatcherDelivery(t *testing.T) {
+	nevents := 1500
+	var rows []string
+	for i := 0; i < nevents; i++ {
+		rows = append(rows, fmt.Sprintf(`{"seq": "%d-a", "id": "e%d", "doc": {"_id": "e%d", "_rev": "1-x", "RequestName": "req", "Status": "Available"}}`, i, i, i))
+	}
+	feed := fmt.Sprintf(`{"results": [%s], "last_seq": "%d-a"}`, strings.Join(rows, ","), nevents-1)
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, feed)
+	}))
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "workqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "changes.checkpoint")
+
+	events := core.Subscribe()
+	defer core.Unsubscribe(events)
+	done := make(chan error)
+	go func() {
+		done <- core.NewChangesWatcher(db.URL, checkpoint, 1).Poll()
+	}()
+	// subscriber starts to consume events once its buffer is full
+	for len(events) < cap(events) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := os.Stat(checkpoint); err == nil {
+		t.Error("checkpoint should not be saved before events are delivered")
+	}
+	for i := 0; i < nevents; i++ {
+		if event := <-events; event.ID != fmt.Sprintf("e%d", i) {
+			t.Fatalf("wrong event %+v", event)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unable to poll changes: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(checkpoint); string(data) != fmt.Sprintf("%d-a", nevents-1) {
+		t.Errorf("wrong checkpoint %s", string(data))
+	}
+}
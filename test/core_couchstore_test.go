@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/zemirco/couchdb"
+)
+
+// couchDB implements Get, Put, Delete and Bulk methods of couchdb.DatabaseService
+// on top of in-memory documents, revisions are checked the way CouchDB does
+type couchDB struct {
+	couchdb.DatabaseService
+	docs map[string][]byte
+	revs map[string]int
+}
+
+func (db *couchDB) rev(id string) string {
+	return fmt.Sprintf("%d-couch", db.revs[id])
+}
+
+func (db *couchDB) Get(doc couchdb.CouchDoc, id string) error {
+	data, ok := db.docs[id]
+	if !ok {
+		return &couchdb.Error{Method: "GET", StatusCode: http.StatusNotFound, Type: "not_found", Reason: "missing"}
+	}
+	return json.Unmarshal(data, doc)
+}
+
+func (db *couchDB) Put(doc couchdb.CouchDoc) (*couchdb.DocumentResponse, error) {
+	id := doc.GetID()
+	if _, ok := db.docs[id]; ok && doc.GetRev() != db.rev(id) || !ok && doc.GetRev() != "" {
+		return nil, &couchdb.Error{Method: "PUT", StatusCode: http.StatusConflict, Type: "conflict", Reason: "Document update conflict."}
+	}
+	db.revs[id]++
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	// stored document keeps its new revision
+	var rec map[string]interface{}
+	json.Unmarshal(data, &rec)
+	rec["_rev"] = db.rev(id)
+	db.docs[id], _ = json.Marshal(rec)
+	return &couchdb.DocumentResponse{Ok: true, ID: id, Rev: db.rev(id)}, nil
+}
+
+func (db *couchDB) Delete(doc couchdb.CouchDoc) (*couchdb.DocumentResponse, error) {
+	id := doc.GetID()
+	if _, ok := db.docs[id]; !ok {
+		return nil, &couchdb.Error{Method: "DELETE", StatusCode: http.StatusNotFound, Type: "not_found", Reason: "deleted"}
+	}
+	if doc.GetRev() != db.rev(id) {
+		return nil, &couchdb.Error{Method: "DELETE", StatusCode: http.StatusConflict, Type: "conflict", Reason: "Document update conflict."}
+	}
+	delete(db.docs, id)
+	return &couchdb.DocumentResponse{Ok: true, ID: id}, nil
+}
+
+func (db *couchDB) Bulk(docs []couchdb.CouchDoc) ([]couchdb.DocumentResponse, error) {
+	var out []couchdb.DocumentResponse
+	for _, doc := range docs {
+		resp, err := db.Put(doc)
+		if err != nil {
+			out = append(out, couchdb.DocumentResponse{ID: doc.GetID()})
+			continue
+		}
+		out = append(out, *resp)
+	}
+	return out, nil
+}
+
+// TestCouchStoreErrors tests that core.CouchStore reports CouchDB conflicts
+// and missing documents as core.ErrConflict and core.ErrNotFound
+func TestCouchStoreErrors(t *testing.T) {
+	store := core.NewCouchStore(&couchDB{docs: make(map[string][]byte), revs: make(map[string]int)})
+	if _, err := store.Get("missing"); err != core.ErrNotFound {
+		t.Errorf("wrong error of missing element: %v", err)
+	}
+	wqe := newElement("req", "/a/b/c#1", []string{"T1_US_FNAL"}, 1)
+	wqe.ID = core.ElementID(wqe)
+	if err := store.Update(&wqe); err != core.ErrNotFound {
+		t.Errorf("wrong error of missing element update: %v", err)
+	}
+	elements := []core.WorkQueueElement{wqe}
+	if _, err := store.Insert(elements); err != nil {
+		t.Fatalf("unable to store element: %v", err)
+	}
+	wqe = elements[0]
+	unversioned := wqe
+	unversioned.Rev = ""
+	if err := store.Update(&unversioned); err != core.ErrConflict {
+		t.Errorf("wrong error of element update without revision: %v", err)
+	}
+	stale := wqe
+	wqe.Priority = 10
+	if err := store.Update(&wqe); err != nil {
+		t.Fatalf("unable to update element: %v", err)
+	}
+	if err := store.Update(&stale); err != core.ErrConflict {
+		t.Errorf("wrong error of stale element update: %v", err)
+	}
+	if err := store.Delete(stale); err != core.ErrConflict {
+		t.Errorf("wrong error of stale element delete: %v", err)
+	}
+	if err := store.Delete(wqe); err != nil {
+		t.Fatalf("unable to delete element: %v", err)
+	}
+	if err := store.Delete(wqe); err != core.ErrNotFound {
+		t.Errorf("wrong error of deleted element: %v", err)
+	}
+}
+
+// TestCouchStoreBulkErrors tests that core.CouchStore merges elements of
+// conflicting bulk rows and reports errors of other failed rows
+func TestCouchStoreBulkErrors(t *testing.T) {
+	db := &couchDB{docs: make(map[string][]byte), revs: make(map[string]int)}
+	store := core.NewCouchStore(db)
+	stored := newElement("req", "/a/b/c#1", []string{"T1_US_FNAL"}, 1)
+	if _, err := store.Insert([]core.WorkQueueElement{stored}); err != nil {
+		t.Fatalf("unable to store element: %v", err)
+	}
+	stored.ID = core.ElementID(stored)
+	inserted := newElement("req", "/a/b/c#2", []string{"T1_US_FNAL"}, 1)
+	inserted.ID = core.ElementID(inserted)
+	invalid := newElement("req", "/a/b/c#3", []string{"T1_US_FNAL"}, 1)
+	invalid.ID = core.ElementID(invalid)
+
+	// CouchDB accepts one document and rejects others with row errors
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/workqueue/_bulk_docs" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Docs []core.WorkQueueElement `json:"docs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var rows []map[string]interface{}
+		for _, doc := range req.Docs {
+			switch doc.ID {
+			case inserted.ID:
+				rows = append(rows, map[string]interface{}{"id": doc.ID, "ok": true, "rev": "1-bulk"})
+			case stored.ID:
+				rows = append(rows, map[string]interface{}{"id": doc.ID, "error": "conflict", "reason": "Document update conflict."})
+			default:
+				rows = append(rows, map[string]interface{}{"id": doc.ID, "error": "forbidden", "reason": "invalid element"})
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rows)
+	}))
+	defer server.Close()
+	store.Url = server.URL + "/workqueue"
+
+	again := newElement("req", "/a/b/c#1", []string{"T1_US_FNAL"}, 5)
+	elements := []core.WorkQueueElement{again, inserted, invalid}
+	summary, err := store.Insert(elements)
+	if err == nil {
+		t.Error("insert with failed row should return error")
+	}
+	if len(summary.Inserted) != 1 || summary.Inserted[0] != inserted.ID || elements[1].Rev != "1-bulk" {
+		t.Errorf("wrong inserted elements %s", summary.String())
+	}
+	if len(summary.Merged) != 1 || summary.Merged[0] != stored.ID {
+		t.Errorf("wrong merged elements %s", summary.String())
+	}
+	if wqe, err := store.Get(stored.ID); err != nil || wqe.Priority != 5 {
+		t.Errorf("merged element should have new priority %+v %v", wqe, err)
+	}
+	if reason := summary.Failed[invalid.ID]; !strings.Contains(reason, "forbidden: invalid element") {
+		t.Errorf("failed element should keep error of its row %q", reason)
+	}
+}
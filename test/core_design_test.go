@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/zemirco/couchdb"
+)
+
+// designDB implements Get and Put methods of couchdb.DatabaseService on top
+// of in-memory documents
+type designDB struct {
+	couchdb.DatabaseService
+	docs map[string][]byte
+	puts int
+	err  error // error of Get method, e.g. unauthorized access
+}
+
+func (db *designDB) Get(doc couchdb.CouchDoc, id string) error {
+	if db.err != nil {
+		return db.err
+	}
+	data, ok := db.docs[id]
+	if !ok {
+		return &couchdb.Error{Method: "GET", StatusCode: http.StatusNotFound, Type: "not_found", Reason: "missing"}
+	}
+	return json.Unmarshal(data, doc)
+}
+
+func (db *designDB) Put(doc couchdb.CouchDoc) (*couchdb.DocumentResponse, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	db.docs[doc.GetID()] = data
+	db.puts++
+	return &couchdb.DocumentResponse{Ok: true, ID: doc.GetID()}, nil
+}
+
+// TestInstallDesign tests core.InstallDesign function
+func TestInstallDesign(t *testing.T) {
+	db := &designDB{docs: make(map[string][]byte)}
+	design := core.WorkQueueDesign()
+	for _, view := range []string{"elementsByWorkflow", "elementsByStatus", "elementsBySite", "availableByPriority", "requestTotals"} {
+		if _, ok := design.Views[view]; !ok {
+			t.Errorf("design document does not provide %s view", view)
+		}
+	}
+	if err := core.InstallDesign(db); err != nil || db.puts != 1 {
+		t.Fatalf("unable to install design document, puts=%d error=%v", db.puts, err)
+	}
+	if err := core.InstallDesign(db); err != nil || db.puts != 1 {
+		t.Errorf("installed design document should not be changed, puts=%d error=%v", db.puts, err)
+	}
+
+	// older design document is upgraded
+	design.Version = core.DesignVersion - 1
+	db.docs[design.ID], _ = json.Marshal(design)
+	if err := core.InstallDesign(db); err != nil || db.puts != 2 {
+		t.Errorf("older design document should be upgraded, puts=%d error=%v", db.puts, err)
+	}
+
+	// newer design document is not supported
+	design.Version = core.DesignVersion + 1
+	db.docs[design.ID], _ = json.Marshal(design)
+	if err := core.InstallDesign(db); err == nil {
+		t.Error("newer design document should be rejected")
+	}
+
+	// design document is not installed if database can't be read
+	db = &designDB{docs: make(map[string][]byte)}
+	db.err = &couchdb.Error{Method: "GET", StatusCode: http.StatusUnauthorized, Type: "unauthorized", Reason: "missing credentials"}
+	if err := core.InstallDesign(db); err == nil || db.puts != 0 {
+		t.Errorf("design document should not be installed, puts=%d error=%v", db.puts, err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// TestSingleShotPolicy tests core.SingleShotPolicy evaluation
+func TestSingleShotPolicy(t *testing.T) {
+	config := utils.Record{"policies.end.policyName": "SingleShot", "policies.end.SuccessThreshold": "0.8"}
+	elements := []core.WorkQueueElement{
+		{EndPolicy: "SingleShot", Jobs: 30, PercentComplete: 100, PercentSuccess: 100},
+		{EndPolicy: "SingleShot", Jobs: 10, PercentComplete: 50, PercentSuccess: 50},
+	}
+	status, err := core.EvaluateEndPolicy(elements, config)
+	if err != nil || status != core.RequestRunning {
+		t.Errorf("request with incomplete elements should be running, status=%s err=%v", status, err)
+	}
+	elements[1].PercentComplete = 100
+	status, _ = core.EvaluateEndPolicy(elements, config)
+	if status != core.RequestDone { // (30*1.0+10*0.5)/40 = 0.875
+		t.Errorf("request should be done, status=%s", status)
+	}
+	elements[1].Jobs = 50
+	status, _ = core.EvaluateEndPolicy(elements, config)
+	if status != core.RequestFailed { // (30*1.0+50*0.5)/80 = 0.69
+		t.Errorf("request should fail, status=%s", status)
+	}
+	if _, err := core.NewEndPolicy("Unknown", config); err == nil {
+		t.Error("unknown end policy should not be created")
+	}
+}
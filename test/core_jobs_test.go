@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+)
+
+// TestSplittingJobs tests core.Splitting job estimates
+func TestSplittingJobs(t *testing.T) {
+	files, lumis, events := 10, 100, 25000
+	tests := []struct {
+		splitting core.Splitting
+		expect    int
+	}{
+		{core.Splitting{Algorithm: "EventBased", EventsPerJob: 1000}, 25},
+		{core.Splitting{Algorithm: "LumiBased", LumisPerJob: 8}, 13},
+		{core.Splitting{Algorithm: "FileBased", FilesPerJob: 3}, 4},
+		{core.Splitting{Algorithm: "EventAwareLumiBased", EventsPerJob: 100}, 100},
+		{core.Splitting{Algorithm: "EventAwareLumiBased", EventsPerJob: 5000}, 5},
+		{core.Splitting{Algorithm: "LumiBased"}, files},
+	}
+	for _, test := range tests {
+		jobs := test.splitting.Jobs(files, lumis, events)
+		if jobs != test.expect {
+			t.Errorf("wrong number of jobs for %v: jobs=%d != expect=%d", test.splitting, jobs, test.expect)
+		}
+	}
+}
+
+// TestBlowupFactor tests core.BlowupFactor of TaskChain requests
+func TestBlowupFactor(t *testing.T) {
+	var rec map[string]interface{}
+	spec := `{"TaskChain": 3, "Task1": {"EventsPerJob": 1000}, "Task2": {"EventsPerJob": 500}, "Task3": {"EventsPerJob": 1000}}`
+	dec := json.NewDecoder(strings.NewReader(spec))
+	dec.UseNumber()
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatal(err)
+	}
+	// Task2 creates twice more jobs than Task1, Task3 the same number as Task1
+	factor := core.BlowupFactor(rec)
+	if factor != 4 {
+		t.Errorf("wrong blowup factor %v != 4", factor)
+	}
+	wqe := core.WorkQueueElement{Jobs: 10, BlowupFactor: factor}
+	if wqe.TotalJobs() != 40 {
+		t.Errorf("wrong total number of jobs %d != 40", wqe.TotalJobs())
+	}
+}
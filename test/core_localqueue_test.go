@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/server"
+)
+
+// TestLocalQueue tests local queue which pulls work from its parent queue and
+// reports progress back to it
+func TestLocalQueue(t *testing.T) {
+	// parent queue runs in the same process and uses its own store, the
+	// local queue is blocked in HTTP call while parent handler is running
+	parentStore := core.NewMemoryStore()
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		localStore := core.Storage
+		core.Storage = parentStore
+		defer func() { core.Storage = localStore }()
+		server.AuthHandler(w, r)
+	}))
+	defer parent.Close()
+
+	production := newElement("production", "/a/b/c#1", []string{"T1_US_FNAL"}, 1)
+	production.Teams = []string{"production"}
+	relval := newElement("relval", "/a/b/c#2", []string{"T1_US_FNAL"}, 1)
+	relval.Teams = []string{"relval"}
+	elements := []core.WorkQueueElement{production, relval}
+	if _, err := parentStore.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	production, relval = elements[0], elements[1]
+
+	core.Storage = core.NewMemoryStore()
+	lq := core.LocalQueue{ParentUrl: parent.URL, Url: "https://local.cern.ch", Team: "production", Sites: map[string]int{"T1_US_FNAL": 100}}
+	err := core.PullWork(lq)
+	if err != nil {
+		t.Fatalf("unable to pull work: %v", err)
+	}
+	local := core.Storage.Request("")
+	if len(local) != 1 || local[0].ParentQueueId != production.ID || local[0].Status != core.Available {
+		t.Fatalf("wrong local elements %v", local)
+	}
+	if wqe, _ := parentStore.Get(production.ID); wqe.Status != core.Acquired || wqe.ChildQueueUrl != lq.Url {
+		t.Errorf("wrong parent element status=%s agent=%s", wqe.Status, wqe.ChildQueueUrl)
+	}
+	if wqe, _ := parentStore.Get(relval.ID); wqe.Status != core.Available {
+		t.Errorf("element of another team should stay Available, status=%s", wqe.Status)
+	}
+
+	// local agent processes the element and local queue reports it to parent
+	agent := "https://agent.cern.ch"
+	elements, err = core.GetWork(core.WorkRequest{Agent: agent, Team: "production", Sites: map[string]int{"T1_US_FNAL": 10}})
+	if err != nil || len(elements) != 1 {
+		t.Fatalf("unable to get local work %v, error %v", elements, err)
+	}
+	report := core.ElementProgress{ID: elements[0].ID, Agent: agent, Status: core.Done, JobsDone: 1}
+	if _, errs := core.UpdateProgress([]core.ElementProgress{report}); len(errs) > 0 {
+		t.Fatalf("unable to update progress: %v", errs)
+	}
+	if err = core.ReportProgress(lq); err != nil {
+		t.Fatalf("unable to report progress: %v", err)
+	}
+	wqe, _ := parentStore.Get(production.ID)
+	if wqe.Status != core.Done || wqe.PercentComplete != 100 || wqe.JobsDone != 1 {
+		t.Errorf("wrong parent element status=%s complete=%v jobs=%d", wqe.Status, wqe.PercentComplete, wqe.JobsDone)
+	}
+	if n := len(core.Storage.Request("")); n != 0 {
+		t.Errorf("local elements of finished parent element should be deleted, got %d", n)
+	}
+}
+
+// TestLocalQueueSplit tests split of parent dataset element into local block
+// elements selected by request block lists, and rejected progress reports
+func TestLocalQueueSplit(t *testing.T) {
+	parentStore := core.NewMemoryStore()
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		localStore := core.Storage
+		core.Storage = parentStore
+		defer func() { core.Storage = localStore }()
+		server.AuthHandler(w, r)
+	}))
+	defer parent.Close()
+	cms := newCMSServices()
+	for _, block := range []string{"/a/b/RAW#1", "/a/b/RAW#2", "/a/b/RAW#3"} {
+		cms.addBlock("/a/b/RAW", block, 1, "T1_US_FNAL")
+	}
+	cms.requests["dataset_request"] = map[string]interface{}{"RequestName": "dataset_request", "BlockBlacklist": []string{"/a/b/RAW#2"}}
+	defer cms.start()()
+
+	gwqe := newElement("dataset_request", "/a/b/RAW", []string{"T1_US_FNAL"}, 1)
+	gwqe.Jobs = 4
+	gwqe.NumberOfLumis = 4
+	elements := []core.WorkQueueElement{gwqe}
+	if _, err := parentStore.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	gwqe = elements[0]
+
+	core.Storage = core.NewMemoryStore()
+	lq := core.LocalQueue{ParentUrl: parent.URL, Url: "https://local.cern.ch", Sites: map[string]int{"T1_US_FNAL": 100}}
+	if err := core.PullWork(lq); err != nil {
+		t.Fatalf("unable to pull work: %v", err)
+	}
+	local := core.Storage.Request("dataset_request")
+	if len(local) != 2 {
+		t.Fatalf("wrong number of local elements: nelements=%d != expect=%d", len(local), 2)
+	}
+	for _, wqe := range local {
+		if _, ok := wqe.Inputs["/a/b/RAW#2"]; ok || len(wqe.Inputs) != 1 {
+			t.Errorf("wrong inputs of local element %v", wqe.Inputs)
+		}
+		if wqe.NumberOfLumis != 2 || wqe.Jobs != 2 || wqe.ParentQueueId != gwqe.ID {
+			t.Errorf("wrong local element lumis=%d jobs=%d parent=%s", wqe.NumberOfLumis, wqe.Jobs, wqe.ParentQueueId)
+		}
+	}
+
+	// local elements are kept when parent queue rejects their progress
+	for _, wqe := range local {
+		if err := wqe.SetStatus(core.Failed); err != nil {
+			t.Fatalf("unable to fail local element: %v", err)
+		}
+		if err := core.Storage.Update(&wqe); err != nil {
+			t.Fatalf("unable to update local element: %v", err)
+		}
+	}
+	pwqe, _ := parentStore.Get(gwqe.ID)
+	pwqe.ChildQueueUrl = "https://other.cern.ch"
+	if err := parentStore.Update(&pwqe); err != nil {
+		t.Fatalf("unable to update parent element: %v", err)
+	}
+	if err := core.ReportProgress(lq); err != nil {
+		t.Fatalf("unable to report progress: %v", err)
+	}
+	if n := len(core.Storage.Request("dataset_request")); n != 2 {
+		t.Errorf("local elements of rejected report should be kept, got %d", n)
+	}
+
+	// local elements are deleted when parent element is not found
+	pwqe, _ = parentStore.Get(gwqe.ID)
+	if err := parentStore.Delete(pwqe); err != nil {
+		t.Fatalf("unable to delete parent element: %v", err)
+	}
+	if err := core.ReportProgress(lq); err != nil {
+		t.Fatalf("unable to report progress: %v", err)
+	}
+	if n := len(core.Storage.Request("dataset_request")); n != 0 {
+		t.Errorf("local elements of deleted parent element should be deleted, got %d", n)
+	}
+}
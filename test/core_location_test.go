@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+)
+
+// TestPossibleSites tests core.WorkQueueElement.PossibleSites behavior
+func TestPossibleSites(t *testing.T) {
+	wqe := core.WorkQueueElement{
+		Inputs:     map[string][]string{"/a/b/c#1": {"T1_US_FNAL", "T2_CH_CERN", "T2_US_MIT"}},
+		ParentData: map[string][]string{"/a/b/p#1": {"T1_US_FNAL", "T2_CH_CERN"}},
+		PileupData: map[string][]string{"/pu/b/c": {"T2_CH_CERN", "T2_US_MIT"}},
+		ParentFlag: true,
+	}
+	check := func(expect []string) {
+		sites := wqe.PossibleSites()
+		sort.Strings(sites)
+		if len(sites) != len(expect) {
+			t.Errorf("wrong possible sites %v != %v", sites, expect)
+			return
+		}
+		for i := range sites {
+			if sites[i] != expect[i] {
+				t.Errorf("wrong possible sites %v != %v", sites, expect)
+				return
+			}
+		}
+	}
+	check([]string{"T2_CH_CERN"})
+	wqe.ParentFlag = false
+	check([]string{"T2_CH_CERN", "T2_US_MIT"})
+	wqe.NoPileupUpdate = true
+	check([]string{"T1_US_FNAL", "T2_CH_CERN", "T2_US_MIT"})
+	wqe.SiteWhiteList = []string{"T1_US_FNAL", "T2_US_MIT"}
+	check([]string{"T1_US_FNAL", "T2_US_MIT"})
+	wqe.SiteBlackList = []string{"T2_US_MIT"}
+	check([]string{"T1_US_FNAL"})
+	wqe.NoInputUpdate = true
+	wqe.SiteWhiteList = []string{"T2_US_Nebraska", "T2_US_MIT"}
+	check([]string{"T2_US_Nebraska"})
+	wqe.SiteBlackList = wqe.SiteWhiteList
+	check(nil)
+}
+
+// TestUpdateLocation tests core.WorkQueueElement.UpdateLocation behavior
+func TestUpdateLocation(t *testing.T) {
+	wqe := core.WorkQueueElement{
+		Inputs:     map[string][]string{"/a/b/c#1": {"T1_US_FNAL"}},
+		PileupData: map[string][]string{"/pu/b/c": {"T2_CH_CERN"}},
+		NoLocation: true,
+	}
+	locations := map[string][]string{
+		"/a/b/c#1": {"T2_CH_CERN", "T1_US_FNAL"},
+		"/pu/b/c":  {"T2_CH_CERN"},
+	}
+	if !wqe.UpdateLocation(locations) {
+		t.Error("element locations should be changed")
+	}
+	if len(wqe.Inputs["/a/b/c#1"]) != 2 {
+		t.Errorf("wrong input locations %v", wqe.Inputs)
+	}
+	if wqe.NoLocation {
+		t.Error("element with common input and pileup site should have location")
+	}
+	if wqe.UpdateLocation(locations) {
+		t.Error("element locations should not be changed by the same locations")
+	}
+	wqe.NoPileupUpdate = true
+	if wqe.UpdateLocation(map[string][]string{"/pu/b/c": {"T2_US_MIT"}, "/a/b/c#1": {}}) {
+		t.Error("element with NoPileupUpdate should keep pileup locations")
+	}
+	wqe.NoInputUpdate = true
+	if wqe.UpdateLocation(map[string][]string{"/a/b/c#1": {"T2_US_MIT"}}) {
+		t.Error("element with NoInputUpdate should keep input locations")
+	}
+}
+
+// TestRefreshLocations tests core.RefreshLocations behavior, dataset is
+// located at sites which hold all of its blocks
+func TestRefreshLocations(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	cms := newCMSServices()
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#1", 1, "T1_US_FNAL", "T2_CH_CERN")
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#2", 1, "T2_CH_CERN")
+	defer cms.start()()
+
+	block := newElement("block", "/a/b/RAW#1", []string{"T1_US_FNAL"}, 1)
+	dataset := newElement("dataset", "/a/b/RAW", []string{"T1_US_FNAL"}, 1)
+	acquired := newElement("acquired", "/a/b/RAW#2", []string{"T1_US_FNAL"}, 1)
+	acquired.SetStatus(core.Acquired)
+	elements := []core.WorkQueueElement{block, dataset, acquired}
+	if _, err := core.Storage.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	if n := core.RefreshLocations(elements); n != 2 {
+		t.Errorf("wrong number of updated elements: %d != %d", n, 2)
+	}
+	expect := map[string]string{
+		"block":    "[T1_US_FNAL T2_CH_CERN]",
+		"dataset":  "[T2_CH_CERN]",
+		"acquired": "[T1_US_FNAL]",
+	}
+	for _, wqe := range core.Storage.Request("") {
+		for _, sites := range wqe.Inputs {
+			if fmt.Sprintf("%v", sites) != expect[wqe.RequestName] {
+				t.Errorf("wrong sites of %s element %v != %s", wqe.RequestName, sites, expect[wqe.RequestName])
+			}
+		}
+	}
+}
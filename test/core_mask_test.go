@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/services"
+)
+
+// helper function to create masked block with one file per run
+func maskedBlock() services.MaskedBlock {
+	var filesLumis []services.FileLumis
+	for _, run := range []int64{1, 2, 3} {
+		runLumis := services.RunLumis{Run: run, Lumis: []int64{1, 2, 3, 4}, Events: []int64{10, 10, 10, 10}}
+		filesLumis = append(filesLumis, services.FileLumis{Lfn: "a.root", RunLumis: runLumis})
+	}
+	return services.MaskedBlock{Block: "/a/b/c#123", FilesLumis: filesLumis}
+}
+
+// TestRunLumiFilter tests core.RunLumiFilter behavior
+func TestRunLumiFilter(t *testing.T) {
+	var rec map[string]interface{}
+	spec := `{"RunWhitelist": [1, 2], "RunBlacklist": [2], "LumiList": {"1": [[2, 3]], "3": [[1, 4]]}, "BlockBlacklist": ["/a/b/c#456"]}`
+	if err := json.Unmarshal([]byte(spec), &rec); err != nil {
+		t.Fatal(err)
+	}
+	filter := core.NewRunLumiFilter(rec)
+	if !filter.Active() {
+		t.Error("filter should be active")
+	}
+	blocks := filter.Blocks([]string{"/a/b/c#123", "/a/b/c#456"})
+	if len(blocks) != 1 || blocks[0] != "/a/b/c#123" {
+		t.Errorf("wrong list of selected blocks %v", blocks)
+	}
+	mb := filter.Apply(maskedBlock())
+	// run 1 is whitelisted with lumis 2-3, run 2 is blacklisted, run 3 is not whitelisted
+	if mb.NumberOfFiles() != 1 || mb.NumberOfLumis() != 2 || mb.NumberOfEvents() != 20 {
+		t.Errorf("wrong masked block %v files=%d lumis=%d events=%d", mb, mb.NumberOfFiles(), mb.NumberOfLumis(), mb.NumberOfEvents())
+	}
+	noFilter := core.NewRunLumiFilter(map[string]interface{}{})
+	if noFilter.Active() || noFilter.Apply(maskedBlock()).NumberOfLumis() != 12 {
+		t.Error("empty filter should select all data")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// TestAddNewData tests that new blocks are added to request open for new data
+// and that request is closed when no new data is found during its timeout
+func TestAddNewData(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	cms := newCMSServices()
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#1", 1, "T1_US_FNAL")
+	spec := map[string]interface{}{"RequestName": "open", "InputDataset": "/a/b/RAW", "OpenRunningTimeout": 3600, "RequestStatus": "running-open"}
+	cms.requests["open"] = spec
+	defer cms.start()()
+
+	policy, _ := core.NewPolicy("Block", utils.Record{"open": spec}, nil)
+	elements, err := policy.Split()
+	if err != nil || len(elements) != 1 || !elements[0].OpenForNewData {
+		t.Fatalf("wrong split of open request %v, error %v", elements, err)
+	}
+	elements[0].SetStatus(core.Available)
+	if _, err := core.Storage.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+
+	// new block of input dataset is added to the request
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#2", 1, "T1_US_FNAL")
+	core.AddNewData(utils.Record{"open": core.Storage.Request("open")})
+	elements = core.Storage.Request("open")
+	if len(elements) != 2 {
+		t.Fatalf("wrong number of elements: nelements=%d != expect=%d", len(elements), 2)
+	}
+
+	// no new data is found during the timeout, the element which recently
+	// became available again (e.g. after lease expiry) does not keep request open
+	now := time.Now().Unix()
+	for i := range elements {
+		elements[i].FoundNewData = now - 7200
+		elements[i].Timestamps[core.Available] = now
+		if err := core.Storage.Update(&elements[i]); err != nil {
+			t.Fatalf("unable to update element: %v", err)
+		}
+	}
+	core.AddNewData(utils.Record{"open": core.Storage.Request("open")})
+	for _, wqe := range core.Storage.Request("open") {
+		if wqe.OpenForNewData {
+			t.Errorf("element should be closed to new data %v", wqe.Inputs)
+		}
+	}
+	if status := spec["RequestStatus"]; status != "running-closed" {
+		t.Errorf("wrong ReqMgr2 status of closed request %v", status)
+	}
+}
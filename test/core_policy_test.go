@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// sitePolicy is a custom start policy used to test policy registry
+type sitePolicy struct {
+	record utils.Record
+}
+
+func (p *sitePolicy) Split() ([]core.WorkQueueElement, error) {
+	var out []core.WorkQueueElement
+	for rname := range p.record {
+		out = append(out, core.WorkQueueElement{RequestName: rname})
+	}
+	return out, nil
+}
+
+func (p *sitePolicy) Validate() error {
+	return nil
+}
+
+// TestPolicyRegistry tests core.RegisterPolicy and core.NewPolicy behavior
+func TestPolicyRegistry(t *testing.T) {
+	for _, name := range []string{"Block", "Dataset", "MonteCarlo", "ResubmitBlock"} {
+		if !utils.InList(name, core.Policies()) {
+			t.Errorf("policy %s is not registered, policies %v", name, core.Policies())
+		}
+	}
+	core.RegisterPolicy("SiteBlock", func(record, config utils.Record) core.Policy {
+		return &sitePolicy{record: record}
+	})
+	record := utils.Record{"test_request": map[string]interface{}{"RequestName": "test_request"}}
+	policy, err := core.NewPolicy("SiteBlock", record, nil)
+	if err != nil {
+		t.Fatalf("unable to create registered policy: %v", err)
+	}
+	elements, err := policy.Split()
+	if err != nil || len(elements) != 1 || elements[0].RequestName != "test_request" {
+		t.Errorf("wrong split of custom policy %v %v", elements, err)
+	}
+	if _, err := core.NewPolicy("Unknown", record, nil); err == nil {
+		t.Error("unknown policy should not be created")
+	}
+}
+
+// TestPolicyValidate tests rejection of invalid requests
+func TestPolicyValidate(t *testing.T) {
+	record := utils.Record{"test_request": map[string]interface{}{"RequestName": "test_request"}}
+	for _, name := range []string{"Block", "Dataset", "MonteCarlo", "ResubmitBlock"} {
+		policy, err := core.NewPolicy(name, record, nil)
+		if err != nil {
+			t.Fatalf("unable to create %s policy: %v", name, err)
+		}
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%s policy should reject request without input", name)
+		}
+	}
+	spec := map[string]interface{}{"RequestName": "test_request", "RequestNumEvents": 1000}
+	policy, _ := core.NewPolicy("MonteCarlo", utils.Record{"test_request": spec}, nil)
+	if err := policy.Validate(); err != nil {
+		t.Errorf("MonteCarlo policy should accept request with events, %v", err)
+	}
+}
+
+// TestMonteCarloSplit tests slicing of MonteCarlo request into elements
+func TestMonteCarloSplit(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	type slice struct {
+		firstEvent, lastEvent int64
+		firstLumi, lastLumi   int
+		jobs, events          int
+	}
+	tests := []struct {
+		name   string
+		spec   map[string]interface{}
+		config utils.Record
+		run    int
+		expect []slice
+	}{
+		{
+			name:   "elements limited by MaxJobsPerElement",
+			spec:   map[string]interface{}{"RequestNumEvents": 2500, "EventsPerJob": 100},
+			config: utils.Record{"policies.start.MaxJobsPerElement": 10},
+			run:    1,
+			expect: []slice{{1, 1000, 1, 10, 10, 1000}, {1001, 2000, 11, 20, 10, 1000}, {2001, 2500, 21, 25, 5, 500}},
+		},
+		{
+			name:   "SliceSize overrides EventsPerJob",
+			spec:   map[string]interface{}{"RequestNumEvents": 1000, "EventsPerJob": 100},
+			config: utils.Record{"policies.start.SliceSize": 500, "policies.start.MaxJobsPerElement": 1},
+			run:    1,
+			expect: []slice{{1, 500, 1, 1, 1, 500}, {501, 1000, 2, 2, 1, 500}},
+		},
+		{
+			name:   "request defines first event, lumi and run",
+			spec:   map[string]interface{}{"RequestNumEvents": 300, "EventsPerJob": 100, "EventsPerLumi": 50, "FirstEvent": 101, "FirstLumi": 5, "FirstRun": 7},
+			run:    7,
+			expect: []slice{{101, 400, 5, 10, 3, 300}},
+		},
+	}
+	for _, test := range tests {
+		test.spec["RequestName"] = "mc_request"
+		policy, err := core.NewPolicy("MonteCarlo", utils.Record{"mc_request": test.spec}, test.config)
+		if err != nil {
+			t.Fatalf("%s: unable to create policy: %v", test.name, err)
+		}
+		elements, err := policy.Split()
+		if err != nil {
+			t.Fatalf("%s: unable to split request: %v", test.name, err)
+		}
+		if len(elements) != len(test.expect) {
+			t.Fatalf("%s: wrong number of elements %d != %d", test.name, len(elements), len(test.expect))
+		}
+		for i, wqe := range elements {
+			m := wqe.Mask
+			got := slice{m.FirstEvent, m.LastEvent, m.FirstLumi, m.LastLumi, wqe.Jobs, wqe.NumberOfEvents}
+			if got != test.expect[i] {
+				t.Errorf("%s: wrong element %d %+v != %+v", test.name, i, got, test.expect[i])
+			}
+			if m.FirstRun != test.run || m.LastRun != test.run {
+				t.Errorf("%s: wrong run range %d-%d of element %d", test.name, m.FirstRun, m.LastRun, i)
+			}
+		}
+	}
+}
+
+// TestBlockSplit tests that Block request produces one element per block
+// with block counts and sites
+func TestBlockSplit(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	cms := newCMSServices()
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#1", 2, "T1_US_FNAL_Disk", "T2_CH_CERN")
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#2", 1, "T2_CH_CERN")
+	cms.addBlock("/a/b/GEN", "/a/b/GEN#1", 1, "T2_CH_CERN")
+	cms.parents["/a/b/RAW#1"] = []string{"/a/b/GEN#1"}
+	cms.addBlock("/pu/b/PU", "/pu/b/PU#1", 1, "T1_US_FNAL_Disk", "T2_CH_CERN")
+	cms.addBlock("/pu/b/PU", "/pu/b/PU#2", 1, "T2_CH_CERN")
+	defer cms.start()()
+
+	spec := map[string]interface{}{"RequestName": "block_request", "InputDataset": "/a/b/RAW", "IncludeParents": true, "MCPileup": "/pu/b/PU"}
+	policy, _ := core.NewPolicy("Block", utils.Record{"block_request": spec}, nil)
+	elements, err := policy.Split()
+	if err != nil {
+		t.Fatalf("unable to split Block request: %v", err)
+	}
+	if len(elements) != 2 {
+		t.Fatalf("wrong number of elements: nelements=%d != expect=%d", len(elements), 2)
+	}
+	tests := map[string]struct {
+		files, lumis, events int
+		sites, parents       []string
+	}{
+		"/a/b/RAW#1": {2, 4, 40, []string{"T1_US_FNAL_Disk", "T2_CH_CERN"}, []string{"/a/b/GEN#1"}},
+		"/a/b/RAW#2": {1, 2, 20, []string{"T2_CH_CERN"}, nil},
+	}
+	for _, wqe := range elements {
+		if len(wqe.Inputs) != 1 {
+			t.Fatalf("element should have single input block, %v", wqe.Inputs)
+		}
+		for block, sites := range wqe.Inputs {
+			test, ok := tests[block]
+			if !ok {
+				t.Fatalf("unexpected input block %s", block)
+			}
+			if wqe.NumberOfFiles != test.files || wqe.NumberOfLumis != test.lumis || wqe.NumberOfEvents != test.events {
+				t.Errorf("wrong counts of block %s element, files=%d lumis=%d events=%d", block, wqe.NumberOfFiles, wqe.NumberOfLumis, wqe.NumberOfEvents)
+			}
+			if fmt.Sprintf("%v", sites) != fmt.Sprintf("%v", test.sites) {
+				t.Errorf("wrong sites of block %s, %v != %v", block, sites, test.sites)
+			}
+			if len(wqe.ParentData) != len(test.parents) {
+				t.Errorf("wrong parents of block %s, %v != %v", block, wqe.ParentData, test.parents)
+			}
+			for _, parent := range test.parents {
+				if fmt.Sprintf("%v", wqe.ParentData[parent]) != "[T2_CH_CERN]" {
+					t.Errorf("wrong sites of parent block %s, %v", parent, wqe.ParentData[parent])
+				}
+			}
+			// pileup dataset is available at sites which hold all of its blocks
+			if fmt.Sprintf("%v", wqe.PileupData) != "map[/pu/b/PU:[T2_CH_CERN]]" {
+				t.Errorf("wrong pileup sites of block %s element, %v", block, wqe.PileupData)
+			}
+		}
+	}
+}
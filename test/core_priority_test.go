@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// TestUpdatePriority tests propagation of request priority to its queued elements
+func TestUpdatePriority(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	core.WorkqueueMetrics = core.Metrics{Jobs: metrics.NewCounter()}
+	sites := []string{"T1_US_FNAL"}
+	available := newElement("req", "/a/b/c#1", sites, 1)
+	acquired := newElement("req", "/a/b/c#2", sites, 1)
+	acquired.SetStatus(core.Acquired)
+	failed := newElement("req", "/a/b/c#3", sites, 1)
+	failed.SetStatus(core.Failed)
+	elements := []core.WorkQueueElement{available, acquired, failed}
+	if _, err := core.Storage.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	priorities := func() map[string]int {
+		out := make(map[string]int)
+		for _, wqe := range core.Storage.Request("req") {
+			out[wqe.Status] = wqe.Priority
+		}
+		return out
+	}
+
+	// request spec without priority keeps priority of elements
+	core.Process(utils.Record{"req": map[string]interface{}{"RequestName": "req"}})
+	if p := priorities(); p[core.Available] != 1 || p[core.Acquired] != 1 {
+		t.Errorf("priority of elements should not change, %v", p)
+	}
+	core.Process(utils.Record{"req": map[string]interface{}{"RequestName": "req", "RequestPriority": 100}})
+	if p := priorities(); p[core.Available] != 100 || p[core.Acquired] != 100 || p[core.Failed] != 1 {
+		t.Errorf("wrong priority of elements %v", p)
+	}
+
+	// clean-up of aborted request updates priority and cancels elements in
+	// the same pass
+	cms := newCMSServices()
+	cms.requests["req"] = map[string]interface{}{"RequestName": "req", "RequestPriority": 200, "RequestStatus": "aborted"}
+	defer cms.start()()
+	core.Cleanup(utils.Record{"req": core.Storage.Request("req")})
+	if p := priorities(); p[core.Canceled] != 200 || p[core.CancelRequested] != 200 {
+		t.Errorf("elements should be canceled with new priority, %v", p)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+)
+
+// TestAggregateProgress tests core.AggregateProgress function
+func TestAggregateProgress(t *testing.T) {
+	elements := []core.WorkQueueElement{
+		{Status: core.Done, Jobs: 30, JobsDone: 30, FilesProcessed: 3, EventsWritten: 300, PercentComplete: 100, PercentSuccess: 100},
+		{Status: core.Running, Jobs: 10, JobsDone: 4, JobsFailed: 1, FilesProcessed: 1, EventsWritten: 40, PercentComplete: 50, PercentSuccess: 80},
+		{Status: core.Available, Jobs: 10},
+	}
+	p := core.AggregateProgress(elements)
+	if p.Elements != 3 || p.Status[core.Done] != 1 || p.Status[core.Running] != 1 || p.Status[core.Available] != 1 {
+		t.Errorf("wrong element counts %+v", p)
+	}
+	if p.Jobs != 50 || p.JobsDone != 34 || p.JobsFailed != 1 {
+		t.Errorf("wrong job counts %+v", p)
+	}
+	if p.FilesProcessed != 4 || p.EventsWritten != 340 {
+		t.Errorf("wrong processed data %+v", p)
+	}
+	if p.PercentComplete != 70 || p.PercentSuccess != 76 {
+		t.Errorf("wrong percentages complete=%v success=%v", p.PercentComplete, p.PercentSuccess)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+)
+
+// TestElementStatus tests core.WorkQueueElement status transitions
+func TestElementStatus(t *testing.T) {
+	var wqe core.WorkQueueElement
+	if err := wqe.SetStatus(core.Running); err == nil {
+		t.Error("new element should not start in Running state")
+	}
+	for _, status := range []string{core.Available, core.Acquired, core.Running, core.Done} {
+		if err := wqe.SetStatus(status); err != nil {
+			t.Fatalf("unable to change status: %v", err)
+		}
+		if _, ok := wqe.Timestamps[status]; !ok {
+			t.Errorf("no timestamp for %s transition", status)
+		}
+	}
+	if !wqe.InEndState() {
+		t.Errorf("element in %s state should be in end state", wqe.Status)
+	}
+	if err := wqe.SetStatus(core.Available); err == nil {
+		t.Error("Done element should not become Available")
+	}
+	if err := wqe.SetStatus("Unknown"); err == nil {
+		t.Error("element should not accept unknown status")
+	}
+	if wqe.Status != core.Done {
+		t.Errorf("rejected transitions should not change status %s", wqe.Status)
+	}
+}
+
+// TestElementCancel tests core.WorkQueueElement cancellation transitions
+func TestElementCancel(t *testing.T) {
+	var wqe core.WorkQueueElement
+	for _, status := range []string{core.Available, core.Acquired, core.CancelRequested} {
+		if err := wqe.SetStatus(status); err != nil {
+			t.Fatalf("unable to change status: %v", err)
+		}
+	}
+	if wqe.InEndState() {
+		t.Error("element with requested cancellation should not be in end state")
+	}
+	if err := wqe.SetStatus(core.Running); err == nil {
+		t.Error("element with requested cancellation should not run")
+	}
+	if err := wqe.SetStatus(core.Canceled); err != nil {
+		t.Fatalf("unable to cancel element: %v", err)
+	}
+	if !wqe.InEndState() {
+		t.Errorf("element in %s state should be in end state", wqe.Status)
+	}
+}
+
+// TestElementLease tests core.WorkQueueElement lease expiry and requeue
+func TestElementLease(t *testing.T) {
+	var wqe core.WorkQueueElement
+	for _, status := range []string{core.Available, core.Acquired} {
+		if err := wqe.SetStatus(status); err != nil {
+			t.Fatalf("unable to change status: %v", err)
+		}
+	}
+	wqe.ChildQueueUrl = "https://agent.cern.ch"
+	wqe.RenewLease()
+	if wqe.LeaseExpired(wqe.LeaseExpire - 1) {
+		t.Error("lease should not expire before its expiry time")
+	}
+	if !wqe.LeaseExpired(wqe.LeaseExpire + 1) {
+		t.Error("lease should expire after its expiry time")
+	}
+	maxRetries := 2
+	for i := 1; i <= maxRetries; i++ {
+		if err := wqe.Requeue(maxRetries); err != nil {
+			t.Fatalf("unable to requeue element: %v", err)
+		}
+		if wqe.Status != core.Available || wqe.Retries != i || wqe.ChildQueueUrl != "" {
+			t.Errorf("wrong requeued element status=%s retries=%d agent=%s", wqe.Status, wqe.Retries, wqe.ChildQueueUrl)
+		}
+		if wqe.LeaseExpired(wqe.LeaseExpire + 1) {
+			t.Error("Available element should not be leased")
+		}
+		wqe.SetStatus(core.Acquired)
+	}
+	if err := wqe.Requeue(maxRetries); err != nil {
+		t.Fatalf("unable to requeue element: %v", err)
+	}
+	if wqe.Status != core.Failed {
+		t.Errorf("element exceeded retries should fail, status=%s", wqe.Status)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vkuznet/WorkQueue/core"
+)
+
+// helper function to create Available element of given request
+func newElement(rname, block string, sites []string, priority int) core.WorkQueueElement {
+	wqe := core.WorkQueueElement{
+		RequestName: rname,
+		Inputs:      map[string][]string{block: sites},
+		Priority:    priority,
+		Jobs:        1,
+	}
+	wqe.SetStatus(core.Available)
+	return wqe
+}
+
+// TestMemoryStore tests core.MemoryStore implementation of core.Store interface
+func TestMemoryStore(t *testing.T) {
+	testStore(t, core.NewMemoryStore())
+}
+
+// TestBoltStore tests core.BoltStore implementation of core.Store interface
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "workqueue.db")
+	store, err := core.NewBoltStore(fname)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	testStore(t, store)
+	wqe := newElement("req3", "/a/b/c#3", []string{"T1_US_FNAL"}, 1)
+	wqe.Teams = []string{"relval"}
+	elements := []core.WorkQueueElement{wqe}
+	if _, err := store.Insert(elements); err != nil {
+		t.Fatalf("unable to insert element: %v", err)
+	}
+	store.Close()
+
+	// elements and their indexes are kept after restart
+	store, err = core.NewBoltStore(fname)
+	if err != nil {
+		t.Fatalf("unable to reopen store: %v", err)
+	}
+	defer store.Close()
+	if n := len(store.Request("")); n != 3 {
+		t.Errorf("wrong number of stored elements %d", n)
+	}
+	if team := store.Team("relval"); len(team) != 1 || team[0].ID != elements[0].ID {
+		t.Errorf("wrong elements of relval team %v", team)
+	}
+	wqe.Teams = []string{"production"}
+	if _, err := store.Insert([]core.WorkQueueElement{wqe}); err != nil {
+		t.Fatalf("unable to insert reassigned element: %v", err)
+	}
+	if team := store.Team("relval"); len(team) != 0 {
+		t.Errorf("reassigned element should leave relval team %v", team)
+	}
+	if team := store.Team("production"); len(team) != 1 || team[0].ID != elements[0].ID {
+		t.Errorf("wrong elements of production team %v", team)
+	}
+	if n := len(store.Query(core.Available, "T1_US_FNAL")); n != 1 {
+		t.Errorf("wrong number of Available elements at T1_US_FNAL %d", n)
+	}
+}
+
+// helper function to test implementation of core.Store interface
+func testStore(t *testing.T, store core.Store) {
+	elements := []core.WorkQueueElement{
+		newElement("req1", "/a/b/c#1", []string{"T1_US_FNAL"}, 1),
+		newElement("req1", "/a/b/c#2", []string{"T2_CH_CERN"}, 1),
+		newElement("req2", "/x/y/z#1", []string{"T2_CH_CERN"}, 5),
+	}
+	summary, err := store.Insert(elements)
+	if err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	if len(summary.Inserted) != 3 || len(summary.Merged) != 0 {
+		t.Errorf("wrong insert summary %s", summary.String())
+	}
+	for _, wqe := range elements {
+		if wqe.ID == "" || wqe.Rev == "" {
+			t.Fatalf("inserted element should have id and revision %+v", wqe.Document)
+		}
+		if wqe.ID != core.ElementID(wqe) {
+			t.Errorf("inserted element should have deterministic id %s", wqe.ID)
+		}
+	}
+
+	// insert of existing element is merged with stored one
+	again := newElement("req1", "/a/b/c#1", []string{"T1_US_FNAL"}, 3)
+	summary, err = store.Insert([]core.WorkQueueElement{again})
+	if err != nil {
+		t.Fatalf("unable to insert existing element: %v", err)
+	}
+	if len(summary.Merged) != 1 || summary.Merged[0] != elements[0].ID || len(summary.Inserted) != 0 {
+		t.Errorf("existing element should be merged %s", summary.String())
+	}
+	if wqe, err := store.Get(elements[0].ID); err != nil || wqe.Priority != 3 {
+		t.Errorf("merged element should have new priority %+v %v", wqe, err)
+	}
+	// request reassigned to another team moves its elements to that team
+	again.Teams = []string{"relval"}
+	if _, err := store.Insert([]core.WorkQueueElement{again}); err != nil {
+		t.Fatalf("unable to insert reassigned element: %v", err)
+	}
+	if wqe, err := store.Get(elements[0].ID); err != nil || len(wqe.Teams) != 1 || wqe.Teams[0] != "relval" {
+		t.Errorf("merged element should have new team %+v %v", wqe.Teams, err)
+	}
+	again.Teams = nil
+	if _, err := store.Insert([]core.WorkQueueElement{again}); err != nil {
+		t.Fatalf("unable to insert reassigned element: %v", err)
+	}
+	if wqe, err := store.Get(elements[0].ID); err != nil || len(wqe.Teams) != 0 {
+		t.Errorf("merged element should not have teams %+v %v", wqe.Teams, err)
+	}
+	moved := newElement("req2", "/x/y/z#1", []string{"T1_US_FNAL"}, 5)
+	if _, err := store.Insert([]core.WorkQueueElement{moved}); err != nil {
+		t.Fatalf("unable to insert existing element: %v", err)
+	}
+	if n := len(store.Query(core.Available, "T2_CH_CERN")); n != 1 {
+		t.Errorf("merged element should leave its old site %d", n)
+	}
+	moved.Inputs["/x/y/z#1"] = []string{"T2_CH_CERN"}
+	if _, err := store.Insert([]core.WorkQueueElement{moved}); err != nil {
+		t.Fatalf("unable to insert existing element: %v", err)
+	}
+	if n := len(store.Request("req1")); n != 2 {
+		t.Errorf("wrong number of req1 elements %d", n)
+	}
+	if n := len(store.Request("")); n != 3 {
+		t.Errorf("wrong number of all elements %d", n)
+	}
+	cern := store.Query(core.Available, "T2_CH_CERN")
+	if len(cern) != 2 || cern[0].RequestName != "req2" {
+		t.Errorf("wrong elements at T2_CH_CERN ordered by priority %v", cern)
+	}
+	if n := len(store.Query(core.Acquired, "")); n != 0 {
+		t.Errorf("wrong number of Acquired elements %d", n)
+	}
+
+	// update requires matching revision
+	wqe, err := store.Get(elements[0].ID)
+	if err != nil {
+		t.Fatalf("unable to get element: %v", err)
+	}
+	unversioned := wqe
+	unversioned.Rev = ""
+	if err := store.Update(&unversioned); err != core.ErrConflict {
+		t.Errorf("update of element without revision should fail with conflict, got %v", err)
+	}
+	missing := newElement("req3", "/x/y/z#2", []string{"T2_CH_CERN"}, 1)
+	missing.ID = core.ElementID(missing)
+	if err := store.Update(&missing); err != core.ErrNotFound {
+		t.Errorf("update of element which is not stored should fail, got %v", err)
+	}
+	stale := wqe
+	wqe.SetStatus(core.Acquired)
+	wqe.Inputs["/a/b/c#1"] = append(wqe.Inputs["/a/b/c#1"], "T2_US_MIT")
+	if err := store.Update(&wqe); err != nil {
+		t.Fatalf("unable to update element: %v", err)
+	}
+	if wqe.Rev == stale.Rev {
+		t.Error("updated element should have new revision")
+	}
+	if err := store.Update(&stale); err != core.ErrConflict {
+		t.Errorf("update of stale element should fail with conflict, got %v", err)
+	}
+	if n := len(store.Query(core.Acquired, "T2_US_MIT")); n != 1 {
+		t.Errorf("wrong number of Acquired elements at T2_US_MIT %d", n)
+	}
+
+	// delete requires matching revision
+	if err := store.Delete(stale); err != core.ErrConflict {
+		t.Errorf("delete of stale element should fail with conflict, got %v", err)
+	}
+	if err := store.Delete(wqe); err != nil {
+		t.Fatalf("unable to delete element: %v", err)
+	}
+	if _, err := store.Get(wqe.ID); err != core.ErrNotFound {
+		t.Errorf("deleted element should not be found, got %v", err)
+	}
+}
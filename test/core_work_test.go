@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/vkuznet/WorkQueue/core"
+	"github.com/vkuznet/WorkQueue/services"
+	"github.com/vkuznet/WorkQueue/utils"
+)
+
+// TestGetWork tests core.GetWork behavior
+func TestGetWork(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	low := newElement("low", "/a/b/c#1", []string{"T1_US_FNAL"}, 1)
+	high := newElement("high", "/a/b/c#2", []string{"T1_US_FNAL"}, 10)
+	relval := newElement("relval", "/a/b/c#3", []string{"T1_US_FNAL"}, 100)
+	relval.Teams = []string{"relval"}
+	cern := newElement("cern", "/a/b/c#4", []string{"T2_CH_CERN"}, 100)
+	if _, err := core.Storage.Insert([]core.WorkQueueElement{low, high, relval, cern}); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	if _, err := core.GetWork(core.WorkRequest{Sites: map[string]int{"T1_US_FNAL": 1}}); err == nil {
+		t.Error("work request without agent should be rejected")
+	}
+
+	// agent with single slot gets the highest priority element of its team
+	req := core.WorkRequest{Agent: "https://agent.cern.ch", Team: "production", Sites: map[string]int{"T1_US_FNAL": 1}}
+	elements, err := core.GetWork(req)
+	if err != nil {
+		t.Fatalf("unable to get work: %v", err)
+	}
+	if len(elements) != 1 || elements[0].RequestName != "high" {
+		t.Fatalf("wrong acquired elements %v", elements)
+	}
+	wqe, _ := core.Storage.Get(elements[0].ID)
+	if wqe.Status != core.Acquired || wqe.ChildQueueUrl != req.Agent || wqe.LeaseExpire == 0 {
+		t.Errorf("wrong acquired element status=%s agent=%s lease=%d", wqe.Status, wqe.ChildQueueUrl, wqe.LeaseExpire)
+	}
+
+	// elements of another team are given only on request of any team
+	req.Sites["T1_US_FNAL"] = 10
+	elements, _ = core.GetWork(req)
+	if len(elements) != 1 || elements[0].RequestName != "low" {
+		t.Fatalf("wrong acquired elements %v", elements)
+	}
+	req.AnyTeam = true
+	elements, _ = core.GetWork(req)
+	if len(elements) != 1 || elements[0].RequestName != "relval" {
+		t.Fatalf("wrong acquired elements %v", elements)
+	}
+	if n := len(core.Storage.Query(core.Available, "")); n != 1 {
+		t.Errorf("element at site without slots should stay Available, got %d", n)
+	}
+
+	// element with more jobs than free slots of any site is still acquired
+	// and overcommits the site, smaller elements wait for next request
+	core.Storage = core.NewMemoryStore()
+	big := newElement("big", "/a/b/c#5", []string{"T1_US_FNAL", "T2_CH_CERN"}, 10)
+	big.Jobs = 5
+	small := newElement("small", "/a/b/c#6", []string{"T1_US_FNAL"}, 1)
+	if _, err := core.Storage.Insert([]core.WorkQueueElement{big, small}); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	elements, _ = core.GetWork(core.WorkRequest{Agent: req.Agent, Sites: map[string]int{"T1_US_FNAL": 3, "T2_CH_CERN": 2}})
+	if len(elements) != 1 || elements[0].RequestName != "big" {
+		t.Fatalf("wrong acquired elements %v", elements)
+	}
+
+	// element of request assigned to many teams is given to agent of any of them
+	core.Storage = core.NewMemoryStore()
+	teams := newElement("teams", "/a/b/c#7", []string{"T1_US_FNAL"}, 1)
+	teams.Teams = []string{"relval", "production"}
+	if _, err := core.Storage.Insert([]core.WorkQueueElement{teams}); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	elements, _ = core.GetWork(core.WorkRequest{Agent: req.Agent, Team: "analysis", Sites: map[string]int{"T1_US_FNAL": 10}})
+	if len(elements) != 0 {
+		t.Fatalf("element should not be given to agent of another team %v", elements)
+	}
+	elements, _ = core.GetWork(core.WorkRequest{Agent: req.Agent, Team: "production", Sites: map[string]int{"T1_US_FNAL": 10}})
+	if len(elements) != 1 || elements[0].RequestName != "teams" {
+		t.Fatalf("wrong acquired elements %v", elements)
+	}
+}
+
+// TestCancelRequest tests core.CancelRequest and its acknowledgement by agent
+func TestCancelRequest(t *testing.T) {
+	core.Storage = core.NewMemoryStore()
+	core.WorkqueueMetrics = core.Metrics{Jobs: metrics.NewCounter()}
+	cms := newCMSServices()
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#1", 1, "T1_US_FNAL")
+	cms.addBlock("/a/b/RAW", "/a/b/RAW#2", 1, "T1_US_FNAL")
+	cms.requests["req"] = map[string]interface{}{"RequestName": "req", "InputDataset": "/a/b/RAW", "RequestStatus": "assigned"}
+	defer cms.start()()
+	agent := "https://agent.cern.ch"
+	elements := []core.WorkQueueElement{
+		newElement("req", "/a/b/RAW#1", []string{"T1_US_FNAL"}, 1),
+		newElement("req", "/a/b/RAW#2", []string{"T1_US_FNAL"}, 1),
+	}
+	elements[0].SetStatus(core.Acquired)
+	elements[0].ChildQueueUrl = agent
+	if _, err := core.Storage.Insert(elements); err != nil {
+		t.Fatalf("unable to insert elements: %v", err)
+	}
+	if n, err := core.CancelRequest("req"); err != nil || n != 2 {
+		t.Fatalf("wrong number of canceled elements %d, error %v", n, err)
+	}
+	ids := core.PendingCancellations(agent)
+	if len(ids) != 1 || ids[0] != elements[0].ID {
+		t.Fatalf("wrong pending cancellations %v", ids)
+	}
+	// agent progress does not change status until it acknowledges cancellation
+	report := core.ElementProgress{ID: ids[0], Agent: agent, Status: core.Running, PercentComplete: 10}
+	if _, errs := core.UpdateProgress([]core.ElementProgress{report}); len(errs) > 0 {
+		t.Fatalf("unable to update progress: %v", errs)
+	}
+	if wqe, _ := core.Storage.Get(ids[0]); wqe.Status != core.CancelRequested || wqe.PercentComplete != 10 {
+		t.Errorf("wrong element status=%s progress=%v", wqe.Status, wqe.PercentComplete)
+	}
+	report.Status = core.Canceled
+	core.UpdateProgress([]core.ElementProgress{report})
+	for _, wqe := range core.Storage.Request("req") {
+		if wqe.Status != core.Canceled {
+			t.Errorf("element %s should be canceled, status=%s", wqe.ID, wqe.Status)
+		}
+	}
+	report.Agent = "https://another.cern.ch"
+	if _, errs := core.UpdateProgress([]core.ElementProgress{report}); len(errs) != 1 {
+		t.Error("progress of element acquired by another agent should be rejected")
+	}
+
+	// canceled request is aborted in ReqMgr2 and is not queued again once
+	// its elements are cleaned up
+	if status := services.RequestSpec("req")["RequestStatus"]; status != "aborted" {
+		t.Errorf("canceled request should be aborted in ReqMgr2, status=%v", status)
+	}
+	core.Cleanup(utils.Record{"req": core.Storage.Request("req")})
+	if n := len(core.Storage.Request("req")); n != 0 {
+		t.Fatalf("canceled elements should be deleted, got %d", n)
+	}
+	for _, record := range services.GetRequests("assigned") {
+		core.Process(record)
+	}
+	if n := len(core.Storage.Request("req")); n != 0 {
+		t.Errorf("canceled request should not be split again, got %d elements", n)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/vkuznet/WorkQueue/services"
+)
+
+// cmsServices fakes DBS, PhEDEx and ReqMgr2 data services, every block file
+// has two lumis of run 1 with 10 events per lumi
+type cmsServices struct {
+	sync.Mutex
+	blocks   map[string][]string               // {dataset: blocks}
+	files    map[string]int                    // {block: number of files}
+	sites    map[string][]string               // {block: sites}
+	parents  map[string][]string               // {block: parent blocks}
+	requests map[string]map[string]interface{} // {request name: request spec}
+}
+
+// helper function to create fake CMS data services
+func newCMSServices() *cmsServices {
+	return &cmsServices{
+		blocks:   make(map[string][]string),
+		files:    make(map[string]int),
+		sites:    make(map[string][]string),
+		parents:  make(map[string][]string),
+		requests: make(map[string]map[string]interface{}),
+	}
+}
+
+// addBlock adds block with given number of files and sites to dataset
+func (s *cmsServices) addBlock(dataset, block string, nfiles int, sites ...string) {
+	s.Lock()
+	defer s.Unlock()
+	s.blocks[dataset] = append(s.blocks[dataset], block)
+	s.files[block] = nfiles
+	s.sites[block] = sites
+}
+
+// start starts fake services and points services package to them, it
+// returns function which stops fake services and restores service urls
+func (s *cmsServices) start() func() {
+	server := httptest.NewServer(s)
+	dbsUrl, phedexUrl, reqmgrUrl := services.DBSUrl, services.PhedexUrl, services.ReqMgrUrl
+	services.DBSUrl = server.URL + "/dbs"
+	services.PhedexUrl = server.URL + "/phedex"
+	services.ReqMgrUrl = server.URL + "/reqmgr2"
+	return func() {
+		services.DBSUrl, services.PhedexUrl, services.ReqMgrUrl = dbsUrl, phedexUrl, reqmgrUrl
+		server.Close()
+	}
+}
+
+// ServeHTTP serves DBS blocks, filelumis, blockparents, PhEDEx blockReplicas
+// and ReqMgr2 request and request status APIs
+func (s *cmsServices) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	query := r.URL.Query()
+	var out interface{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/dbs/blocks"):
+		rows := []map[string]interface{}{}
+		for _, block := range s.blocks[query.Get("dataset")] {
+			rows = append(rows, map[string]interface{}{"block_name": block})
+		}
+		out = rows
+	case strings.HasSuffix(r.URL.Path, "/dbs/filelumis"):
+		block := query.Get("block_name")
+		rows := []map[string]interface{}{}
+		for i := 0; i < s.files[block]; i++ {
+			lfn := fmt.Sprintf("/store/%s/%d.root", strings.Replace(block, "#", "_", -1), i)
+			lumis := []int{2*i + 1, 2*i + 2}
+			row := map[string]interface{}{"logical_file_name": lfn, "run_num": 1, "lumi_section_num": lumis, "event_count": []int{10, 10}}
+			rows = append(rows, row)
+		}
+		out = rows
+	case strings.HasSuffix(r.URL.Path, "/dbs/blockparents"):
+		rows := []map[string]interface{}{}
+		for _, parent := range s.parents[query.Get("block_name")] {
+			rows = append(rows, map[string]interface{}{"parent_block_name": parent})
+		}
+		out = rows
+	case strings.HasSuffix(r.URL.Path, "/phedex/blockReplicas"):
+		blocks := s.blocks[query.Get("dataset")]
+		if block := query.Get("block"); block != "" {
+			blocks = []string{block}
+		}
+		rows := []map[string]interface{}{}
+		for _, block := range blocks {
+			replicas := []map[string]interface{}{}
+			for _, site := range s.sites[block] {
+				replicas = append(replicas, map[string]interface{}{"node": site})
+			}
+			rows = append(rows, map[string]interface{}{"name": block, "replica": replicas})
+		}
+		out = map[string]interface{}{"phedex": map[string]interface{}{"block": rows}}
+	case r.Method == "PUT" && strings.Contains(r.URL.Path, "/reqmgr2/data/request/"):
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		var rec map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&rec)
+		if spec, ok := s.requests[name]; ok {
+			spec["RequestStatus"] = rec["RequestStatus"]
+		}
+		out = map[string]interface{}{"result": []interface{}{}}
+	case strings.HasSuffix(r.URL.Path, "/reqmgr2/data/request"):
+		rows := []map[string]interface{}{}
+		name, status := query.Get("name"), query.Get("status")
+		for rname, spec := range s.requests {
+			if rname == name || status != "" && spec["RequestStatus"] == status {
+				rows = append(rows, map[string]interface{}{rname: spec})
+			}
+		}
+		out = map[string]interface{}{"result": rows}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
@@ -123,7 +123,21 @@ func (r *ResponseType) String() string {
 }
 
 // FetchResponse fetches data for provided URL, args is a json dump of arguments
+// which are sent via POST request, otherwise GET request is used
 func FetchResponse(rurl, args string) ResponseType {
+	if len(args) > 0 {
+		return HttpResponse("POST", rurl, args)
+	}
+	return HttpResponse("GET", rurl, args)
+}
+
+// PutResponse sends PUT request with given json arguments to provided URL
+func PutResponse(rurl, args string) ResponseType {
+	return HttpResponse("PUT", rurl, args)
+}
+
+// HttpResponse fetches data for provided URL using given HTTP method
+func HttpResponse(method, rurl, args string) ResponseType {
 	startTime := time.Now()
 	var response ResponseType
 	response.Url = rurl
@@ -135,12 +149,19 @@ func FetchResponse(rurl, args string) ResponseType {
 	var e error
 	if len(args) > 0 {
 		jsonStr := []byte(args)
-		req, e = http.NewRequest("POST", rurl, bytes.NewBuffer(jsonStr))
+		req, e = http.NewRequest(method, rurl, bytes.NewBuffer(jsonStr))
+		if e != nil {
+			log.Println("Unable to make", method, "request", e)
+			response.Error = e
+			return response
+		}
 		req.Header.Set("Content-Type", "application/json")
 	} else {
-		req, e = http.NewRequest("GET", rurl, nil)
+		req, e = http.NewRequest(method, rurl, nil)
 		if e != nil {
-			log.Println("Unable to make GET request", e)
+			log.Println("Unable to make", method, "request", e)
+			response.Error = e
+			return response
 		}
 		req.Header.Set("Accept", "application/json")
 	}
@@ -158,9 +179,9 @@ func FetchResponse(rurl, args string) ResponseType {
 	response.StatusCode = resp.StatusCode
 	if VERBOSE > 0 {
 		if len(args) > 0 {
-			log.Println("HTTP POST", _client, rurl, string(args), err, time.Now().Sub(startTime))
+			log.Println("HTTP", method, _client, rurl, string(args), err, time.Now().Sub(startTime))
 		} else {
-			log.Println("HTTP GET", _client, rurl, err, time.Now().Sub(startTime))
+			log.Println("HTTP", method, _client, rurl, err, time.Now().Sub(startTime))
 		}
 	}
 	if VERBOSE > 1 {
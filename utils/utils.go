@@ -163,6 +163,17 @@ func List2Set(arr []string) []string {
 	return out
 }
 
+// Intersect helper function to find common items of two lists
+func Intersect(a, b []string) []string {
+	var out []string
+	for _, key := range a {
+		if InList(key, b) && !InList(key, out) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
 // HostIP provides a list of host IPs
 func HostIP() []string {
 	var out []string